@@ -10,6 +10,8 @@ import (
 	"net/http"
 	"os"
 	"strings"
+	"sync"
+	"syscall"
 	"time"
 
 	"github.com/MightyToolkit/mightymonitor-agent/internal/buffer"
@@ -20,9 +22,18 @@ import (
 
 const (
 	defaultConfigPath = "/etc/mightymonitor/config.json"
+	defaultStateDir   = "/var/lib/mightymonitor"
 	defaultBufferPath = "/var/lib/mightymonitor/buffer.jsonl"
 	legacyBufferPath  = "/var/lib/mightymonitor/pending-payloads.jsonl"
 	defaultBufferSize = 10
+	defaultLockPath   = "/var/lib/mightymonitor/agent.lock"
+
+	stateLockTimeout = 2 * time.Minute
+
+	// checksTimeout bounds the systemd collection and the synthetic checks
+	// of one payload, which run concurrently, so a send stays well within
+	// the minute between cron runs.
+	checksTimeout = 30 * time.Second
 )
 
 var Version = "0.1.0"
@@ -40,11 +51,18 @@ func main() {
 				log.Fatalf("%v", err)
 			}
 			return
+		case "sysctl-baseline":
+			if err := runSysctlBaseline(os.Args[2:]); err != nil {
+				log.Fatalf("%v", err)
+			}
+			return
 		case "send":
 			if err := runSend(); err != nil {
 				log.Fatalf("%v", err)
 			}
 			return
+		case "run-job":
+			os.Exit(runJob(os.Args[2:]))
 		case "version", "--version", "-v":
 			fmt.Println(Version)
 			return
@@ -55,20 +73,22 @@ func main() {
 }
 
 func runPrintPayload() error {
-	hostID := "(not enrolled)"
 	cfg, err := config.Load(defaultConfigPath)
-	if err == nil {
-		if cfg.HostID != "" {
-			hostID = cfg.HostID
+	if err != nil {
+		if !os.IsNotExist(err) {
+			return err
 		}
-	} else if !os.IsNotExist(err) {
-		return err
+		cfg = &config.Config{}
+	}
+	if cfg.HostID == "" {
+		cfg.HostID = "(not enrolled)"
 	}
 
-	payload, err := buildPayload(hostID)
+	payload, err := buildPayload(cfg)
 	if err != nil {
 		return err
 	}
+	payload.Inventory = metrics.CollectInventory(defaultStateDir)
 
 	encoder := json.NewEncoder(os.Stdout)
 	encoder.SetIndent("", "  ")
@@ -93,9 +113,10 @@ func runEnroll(args []string) error {
 	}
 
 	hostname := metrics.GetHostname()
+	inventory := metrics.CollectInventory(defaultStateDir)
 	cfg := &config.Config{ServerURL: *server}
 	cli := client.NewClientWithOptions(cfg, *allowInsecure)
-	resp, err := cli.Enroll(context.Background(), *token, hostname)
+	resp, err := cli.Enroll(context.Background(), *token, hostname, inventory)
 	if err != nil {
 		var httpErr *client.HTTPError
 		if errors.As(err, &httpErr) {
@@ -115,11 +136,41 @@ func runEnroll(args []string) error {
 	if err := config.Save(defaultConfigPath, cfg); err != nil {
 		return fmt.Errorf("Error: failed to write config: %w", err)
 	}
+	if err := metrics.SaveInventoryState(defaultStateDir, inventory); err != nil {
+		log.Printf("Warning: failed to save inventory state: %v", err)
+	}
 
 	fmt.Printf("Enrolled successfully. Host ID: %s\n", resp.HostID)
 	return nil
 }
 
+func runSysctlBaseline(args []string) error {
+	fs := flag.NewFlagSet("sysctl-baseline", flag.ContinueOnError)
+	fs.SetOutput(os.Stderr)
+	prefixes := fs.String("prefix", "", "comma-separated sysctl name prefixes to capture (default: all writable)")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	var prefixList []string
+	for _, prefix := range strings.Split(*prefixes, ",") {
+		if prefix = strings.TrimSpace(prefix); prefix != "" {
+			prefixList = append(prefixList, prefix)
+		}
+	}
+
+	values, err := metrics.CaptureSysctlBaseline(prefixList)
+	if err != nil {
+		return fmt.Errorf("Error: failed to read sysctls: %w", err)
+	}
+	if err := metrics.SaveSysctlBaseline(defaultStateDir, values); err != nil {
+		return fmt.Errorf("Error: failed to save sysctl baseline: %w", err)
+	}
+
+	fmt.Printf("Captured %d sysctl values as baseline.\n", len(values))
+	return nil
+}
+
 func runSend() error {
 	cfg, err := config.Load(defaultConfigPath)
 	if err != nil {
@@ -127,12 +178,25 @@ func runSend() error {
 		return nil
 	}
 
-	payload, err := buildPayload(cfg.HostID)
+	unlock, err := lockState()
+	if err != nil {
+		log.Printf("Warning: skipping send: %v", err)
+		return nil
+	}
+	defer unlock()
+
+	payload, err := buildPayload(cfg)
 	if err != nil {
 		log.Printf("Warning: failed to collect metrics: %v", err)
 		return nil
 	}
 
+	// The buffered form of the payload leaves out the changes, which are
+	// detected again until a send succeeds and commits their state.
+	pendingState := &metrics.PendingState{}
+	withChanges := *payload
+	attachChanges(cfg, &withChanges, pendingState)
+
 	migrateLegacyBufferFile(defaultBufferPath, legacyBufferPath)
 
 	payloadBuffer := buffer.NewBuffer(defaultBufferPath, defaultBufferSize)
@@ -176,7 +240,7 @@ func runSend() error {
 		}
 	}
 
-	response, err := cli.SendPayload(context.Background(), payload)
+	response, err := cli.SendPayload(context.Background(), &withChanges)
 	if err != nil {
 		if pushErr := payloadBuffer.Push(payload); pushErr != nil {
 			log.Printf("Warning: send failed and buffering failed: send_err=%v buffer_err=%v", err, pushErr)
@@ -185,25 +249,163 @@ func runSend() error {
 		}
 		return nil
 	}
+	if err := pendingState.Commit(); err != nil {
+		log.Printf("Warning: failed to save change tracking state: %v", err)
+	}
 
+	if payload.Clock != nil && !payload.Clock.Synchronized {
+		log.Printf("Warning: system clock is not NTP-synchronized (state=%s, max error %dus).", payload.Clock.State, payload.Clock.MaxErrorMicros)
+	}
 	if response.ClockSkew {
 		log.Printf("Warning: server detected clock skew > 5 minutes. Consider running ntpd/chrony.")
 	}
 	return nil
 }
 
-func buildPayload(hostID string) (*metrics.Payload, error) {
-	payload, err := metrics.Collect()
+// collectSystemd returns nil on hosts without a system bus.
+func collectSystemd(ctx context.Context, cfg *config.Config) *metrics.SystemdPayload {
+	ctx, cancel := context.WithTimeout(ctx, metrics.SystemdTimeout)
+	defer cancel()
+
+	bus, err := metrics.DialSystemdBus(ctx)
+	if err != nil {
+		if !errors.Is(err, metrics.ErrNoSystemBus) {
+			log.Printf("Warning: systemd connection failed: %v", err)
+		}
+		return nil
+	}
+	defer bus.Close()
+
+	systemd, err := metrics.CollectSystemd(ctx, bus, cfg.CriticalUnits)
+	if err != nil {
+		log.Printf("Warning: systemd collection failed: %v", err)
+		return nil
+	}
+	return systemd
+}
+
+func buildPayload(cfg *config.Config) (*metrics.Payload, error) {
+	payload, err := metrics.Collect(defaultStateDir)
 	if err != nil {
 		return nil, err
 	}
+	payload.Posture = metrics.CollectPosture(cfg.PostureChecks)
+
+	services, err := metrics.CollectServices(defaultStateDir, cfg.Services)
+	if err != nil {
+		if !errors.Is(err, metrics.ErrCgroupV1) {
+			log.Printf("Warning: service resource collection failed: %v", err)
+		}
+	} else {
+		payload.Services = services
+	}
+
+	// Each group sets only its own field, so they can run side by side; a
+	// check still running at the deadline reports a timeout.
+	ctx, cancel := context.WithTimeout(context.Background(), checksTimeout)
+	defer cancel()
+	var wg sync.WaitGroup
+	for _, collect := range []func(){
+		func() { payload.Systemd = collectSystemd(ctx, cfg) },
+		func() { payload.HTTPChecks = metrics.CollectHTTPChecks(ctx, cfg.HTTPChecks) },
+		func() { payload.TLSCerts = metrics.CollectTLSCertificates(ctx, cfg.TLSCertificates) },
+		func() { payload.PortChecks = metrics.CollectPortChecks(ctx, cfg.PortChecks) },
+		func() { payload.DNSChecks = metrics.CollectDNSChecks(ctx, cfg.DNSChecks) },
+		func() { payload.NagiosChecks = metrics.CollectNagiosChecks(ctx, cfg.NagiosChecks) },
+	} {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			collect()
+		}()
+	}
+	wg.Wait()
+	payload.FileChecks = metrics.CollectFileChecks(cfg.FileChecks)
 
-	payload.HostID = hostID
+	payload.HostID = cfg.HostID
 	payload.AgentVersion = Version
 	payload.TS = time.Now().Unix()
 	return payload, nil
 }
 
+// attachChanges adds change-tracking data to a payload that is about to be
+// sent. The collectors stage what they reported in pending, which must only
+// be committed once the server accepted the payload; until then the same
+// changes are reported again. Payloads are therefore buffered without this
+// data, and print-payload does not use it.
+func attachChanges(cfg *config.Config, payload *metrics.Payload, pending *metrics.PendingState) {
+	inventory := metrics.CollectInventory(defaultStateDir)
+	if metrics.InventoryChanged(defaultStateDir, inventory) {
+		payload.Inventory = inventory
+		metrics.StageInventoryState(pending, defaultStateDir, inventory)
+	}
+
+	rebootEvents, err := metrics.DetectReboot(defaultStateDir, time.Now(), pending)
+	if err != nil {
+		log.Printf("Warning: reboot detection failed: %v", err)
+	} else {
+		payload.Events = append(payload.Events, rebootEvents...)
+	}
+
+	packages, err := metrics.CollectPackageChanges(defaultStateDir, pending)
+	if err != nil {
+		log.Printf("Warning: package inventory failed: %v", err)
+	} else {
+		payload.Packages = packages
+	}
+
+	authFailures, err := metrics.CollectAuthFailures(defaultStateDir, pending)
+	if err != nil {
+		log.Printf("Warning: auth failure collection failed: %v", err)
+	} else {
+		payload.AuthFailures = authFailures
+	}
+
+	logMonitors, err := metrics.CollectLogMonitors(defaultStateDir, cfg.LogMonitors, pending)
+	if err != nil {
+		log.Printf("Warning: log monitoring failed: %v", err)
+	} else {
+		payload.LogMonitors = logMonitors
+	}
+
+	accessLogs, err := metrics.CollectAccessLogs(defaultStateDir, cfg.AccessLogs, pending)
+	if err != nil {
+		log.Printf("Warning: access log analysis failed: %v", err)
+	} else {
+		payload.AccessLogs = accessLogs
+	}
+
+	accountEvents, err := metrics.CollectAccountChanges(defaultStateDir, cfg.AuthorizedKeysUsers, pending)
+	if err != nil {
+		log.Printf("Warning: account change detection failed: %v", err)
+	} else {
+		payload.Events = append(payload.Events, accountEvents...)
+	}
+
+	integrityEvents, err := metrics.CollectFileIntegrity(defaultStateDir, cfg.FileIntegrity, pending)
+	if err != nil {
+		log.Printf("Warning: file integrity check failed: %v", err)
+	} else {
+		payload.Events = append(payload.Events, integrityEvents...)
+	}
+
+	sysctl, sysctlEvents, err := metrics.CollectSysctlDrift(defaultStateDir, cfg.Sysctl, pending)
+	if err != nil {
+		log.Printf("Warning: sysctl drift check failed: %v", err)
+	} else {
+		payload.Sysctl = sysctl
+		payload.Events = append(payload.Events, sysctlEvents...)
+	}
+
+	docker, dockerEvents, err := metrics.CollectDocker(defaultStateDir, cfg.DockerSocket, pending)
+	if err != nil {
+		log.Printf("Warning: docker collection failed: %v", err)
+	} else {
+		payload.Docker = docker
+		payload.Events = append(payload.Events, dockerEvents...)
+	}
+}
+
 func isEnrollmentTokenUsedError(httpErr *client.HTTPError) bool {
 	if httpErr == nil || httpErr.StatusCode != http.StatusBadRequest {
 		return false
@@ -233,3 +435,36 @@ func migrateLegacyBufferFile(currentPath string, oldPath string) {
 		log.Printf("Warning: failed to migrate legacy buffer file from %s to %s: %v", oldPath, currentPath, err)
 	}
 }
+
+// lockState takes an exclusive lock on the state directory and the payload
+// buffer, which send and run-job both modify and cron may start at the same
+// time. The returned function releases it.
+func lockState() (func(), error) {
+	if err := os.MkdirAll(defaultStateDir, 0o700); err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(defaultLockPath, os.O_CREATE|os.O_RDWR, 0o600)
+	if err != nil {
+		return nil, err
+	}
+
+	deadline := time.Now().Add(stateLockTimeout)
+	for {
+		err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+		if err == nil {
+			break
+		}
+		if err != syscall.EWOULDBLOCK || time.Now().After(deadline) {
+			f.Close()
+			if err == syscall.EWOULDBLOCK {
+				return nil, fmt.Errorf("timed out waiting for %s", defaultLockPath)
+			}
+			return nil, fmt.Errorf("lock %s: %w", defaultLockPath, err)
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	return func() {
+		_ = syscall.Flock(int(f.Fd()), syscall.LOCK_UN)
+		f.Close()
+	}, nil
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/MightyToolkit/mightymonitor-agent/internal/buffer"
+	"github.com/MightyToolkit/mightymonitor-agent/internal/client"
+	"github.com/MightyToolkit/mightymonitor-agent/internal/config"
+	"github.com/MightyToolkit/mightymonitor-agent/internal/metrics"
+)
+
+const (
+	maxStderrTailBytes = 4096
+	maxStderrTailLines = 20
+
+	// exitCommandNotFound mirrors the shell convention for commands that
+	// could not be started.
+	exitCommandNotFound = 127
+)
+
+// runJob runs the wrapped command, reports a job_run event and returns the
+// command's exit code so cron sees the same result as without the wrapper.
+func runJob(args []string) int {
+	fs := flag.NewFlagSet("run-job", flag.ContinueOnError)
+	fs.SetOutput(os.Stderr)
+	name := fs.String("name", "", "job name reported to the server (required)")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	command := fs.Args()
+	if *name == "" || len(command) == 0 {
+		fmt.Fprintln(os.Stderr, "Usage: mightymonitor-agent run-job --name <name> -- <command> [args...]")
+		return 2
+	}
+
+	cmd := exec.Command(command[0], command[1:]...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	stderrTail := &tailBuffer{max: maxStderrTailBytes}
+	cmd.Stderr = io.MultiWriter(os.Stderr, stderrTail)
+
+	// Forward termination signals so stopping the wrapper stops the job.
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
+	defer signal.Stop(signals)
+
+	startedAt := time.Now()
+	err := cmd.Start()
+	if err == nil {
+		done := make(chan struct{})
+		go func() {
+			for {
+				select {
+				case sig := <-signals:
+					_ = cmd.Process.Signal(sig)
+				case <-done:
+					return
+				}
+			}
+		}()
+		err = cmd.Wait()
+		close(done)
+	}
+	duration := time.Since(startedAt)
+
+	exitCode := 0
+	details := map[string]any{
+		"name":            *name,
+		"command":         command[0],
+		"startedAt":       startedAt.Unix(),
+		"durationSeconds": duration.Seconds(),
+	}
+	var exitErr *exec.ExitError
+	switch {
+	case err == nil:
+	case errors.As(err, &exitErr):
+		exitCode = exitErr.ExitCode()
+		if status, ok := exitErr.Sys().(syscall.WaitStatus); ok && status.Signaled() {
+			exitCode = 128 + int(status.Signal())
+			details["signal"] = status.Signal().String()
+		}
+	default:
+		exitCode = exitCommandNotFound
+		details["error"] = err.Error()
+		fmt.Fprintf(os.Stderr, "run-job: %v\n", err)
+	}
+	details["exitCode"] = exitCode
+	details["success"] = exitCode == 0
+	if cmd.ProcessState != nil {
+		if usage, ok := cmd.ProcessState.SysUsage().(*syscall.Rusage); ok {
+			// Linux reports ru_maxrss in kilobytes.
+			details["peakRssBytes"] = int64(usage.Maxrss) * 1024
+		}
+	}
+	if tail := stderrTail.Lines(maxStderrTailLines); tail != "" {
+		details["stderrTail"] = tail
+	}
+
+	message := fmt.Sprintf("job %s succeeded in %s", *name, duration.Round(time.Millisecond))
+	if exitCode != 0 {
+		message = fmt.Sprintf("job %s failed with exit code %d after %s", *name, exitCode, duration.Round(time.Millisecond))
+	}
+	reportJobRun(metrics.EventPayload{
+		Type:    "job_run",
+		TS:      time.Now().Unix(),
+		Message: message,
+		Details: details,
+	})
+	return exitCode
+}
+
+// reportJobRun sends the event in a payload carrying the host identity and
+// the stateless CPU, memory, disk and uptime readings, so it is a valid
+// sample without running the collectors that keep state. When the server is
+// unreachable the payload is buffered for the next send. Failures are only
+// logged so the job's exit code is never masked.
+func reportJobRun(event metrics.EventPayload) {
+	cfg, err := config.Load(defaultConfigPath)
+	if err != nil {
+		log.Printf("Warning: failed to load config; job run not reported: %v", err)
+		return
+	}
+
+	payload := metrics.CollectBase()
+	payload.HostID = cfg.HostID
+	payload.AgentVersion = Version
+	payload.Events = []metrics.EventPayload{event}
+
+	cli := client.NewClientWithOptions(cfg, cfg.AllowInsecureLocalhost)
+	_, err = cli.SendPayload(context.Background(), payload)
+	if err == nil {
+		return
+	}
+
+	unlock, lockErr := lockState()
+	if lockErr != nil {
+		log.Printf("Warning: job run send failed and buffering failed: send_err=%v buffer_err=%v", err, lockErr)
+		return
+	}
+	defer unlock()
+	payloadBuffer := buffer.NewBuffer(defaultBufferPath, defaultBufferSize)
+	if pushErr := payloadBuffer.Push(payload); pushErr != nil {
+		log.Printf("Warning: job run send failed and buffering failed: send_err=%v buffer_err=%v", err, pushErr)
+	} else {
+		log.Printf("Warning: job run send failed; payload buffered: %v", err)
+	}
+}
+
+// tailBuffer keeps the last max bytes written to it.
+type tailBuffer struct {
+	data []byte
+	max  int
+}
+
+func (b *tailBuffer) Write(p []byte) (int, error) {
+	b.data = append(b.data, p...)
+	if len(b.data) > b.max {
+		b.data = append(b.data[:0], b.data[len(b.data)-b.max:]...)
+	}
+	return len(p), nil
+}
+
+// Lines returns at most n trailing lines, dropping a first line that was
+// cut by the byte limit.
+func (b *tailBuffer) Lines(n int) string {
+	text := strings.ToValidUTF8(string(b.data), "")
+	lines := strings.Split(strings.TrimRight(text, "\n"), "\n")
+	if len(b.data) == b.max && len(lines) > 1 {
+		lines = lines[1:]
+	}
+	if len(lines) > n {
+		lines = lines[len(lines)-n:]
+	}
+	return strings.TrimSpace(strings.Join(lines, "\n"))
+}
@@ -90,23 +90,26 @@ func (b *Buffer) writeAll(items []*metrics.Payload) error {
 	return f.Sync()
 }
 
+// readAll decodes one payload per line. Lines are read without a length
+// limit, since per-container and per-service usage, systemd unit lists and
+// check results can make a payload far larger than a bufio.Scanner token.
 func readAll(r io.Reader) ([]*metrics.Payload, error) {
-	scanner := bufio.NewScanner(r)
+	reader := bufio.NewReader(r)
 	items := make([]*metrics.Payload, 0)
-	for scanner.Scan() {
-		line := strings.TrimSpace(scanner.Text())
-		if line == "" {
-			continue
+	for {
+		data, err := reader.ReadBytes('\n')
+		if err != nil && err != io.EOF {
+			return nil, err
 		}
-
-		var payload metrics.Payload
-		if err := json.Unmarshal([]byte(line), &payload); err != nil {
-			continue
+		line := strings.TrimSpace(string(data))
+		if line != "" {
+			var payload metrics.Payload
+			if jsonErr := json.Unmarshal([]byte(line), &payload); jsonErr == nil {
+				items = append(items, &payload)
+			}
+		}
+		if err == io.EOF {
+			return items, nil
 		}
-		items = append(items, &payload)
-	}
-	if err := scanner.Err(); err != nil {
-		return nil, err
 	}
-	return items, nil
 }
@@ -68,14 +68,17 @@ func (c *Client) SendBatch(ctx context.Context, payloads []*metrics.Payload) (*B
 	return response, nil
 }
 
-func (c *Client) Enroll(ctx context.Context, enrollToken string, hostname string) (*EnrollResponse, error) {
+func (c *Client) Enroll(ctx context.Context, enrollToken string, hostname string, inventory *metrics.InventoryPayload) (*EnrollResponse, error) {
 	if err := c.validateServerURL(); err != nil {
 		return nil, err
 	}
-	request := map[string]string{
+	request := map[string]any{
 		"token":    enrollToken,
 		"hostname": hostname,
 	}
+	if inventory != nil {
+		request["inventory"] = inventory
+	}
 	response := &EnrollResponse{}
 	if err := c.postJSON(ctx, "/v1/enroll", request, false, response); err != nil {
 		return nil, err
@@ -11,6 +11,156 @@ type Config struct {
 	HostToken              string `json:"host_token"`
 	ServerURL              string `json:"server_url"`
 	AllowInsecureLocalhost bool   `json:"allow_insecure_localhost,omitempty"`
+
+	// AuthorizedKeysUsers lists accounts whose ~/.ssh/authorized_keys are
+	// watched for added and removed keys.
+	AuthorizedKeysUsers []string `json:"authorized_keys_users,omitempty"`
+
+	FileIntegrity *FileIntegrityConfig `json:"file_integrity,omitempty"`
+
+	// PostureChecks adds to or, by reusing an ID, replaces the built-in
+	// security posture checks.
+	PostureChecks []PostureCheck `json:"posture_checks,omitempty"`
+
+	// Sysctl maps sysctl names to their expected values. These take
+	// precedence over a baseline captured with sysctl-baseline.
+	Sysctl map[string]string `json:"sysctl,omitempty"`
+
+	// Services limits per-service resource reporting to these systemd units.
+	// A template name such as "postgresql@" selects all of its instances.
+	// Empty reports every service in system.slice.
+	Services []string `json:"services,omitempty"`
+
+	// CriticalUnits are systemd units whose state is always reported. Names
+	// without a suffix are treated as services.
+	CriticalUnits []string `json:"critical_units,omitempty"`
+
+	// DockerSocket overrides the Docker Engine API socket path.
+	DockerSocket string `json:"docker_socket,omitempty"`
+
+	HTTPChecks []HTTPCheck `json:"http_checks,omitempty"`
+
+	TLSCertificates *TLSCertificatesConfig `json:"tls_certificates,omitempty"`
+
+	PortChecks []PortCheck `json:"port_checks,omitempty"`
+
+	DNSChecks []DNSCheck `json:"dns_checks,omitempty"`
+
+	NagiosChecks []NagiosCheck `json:"nagios_checks,omitempty"`
+
+	LogMonitors []LogMonitor `json:"log_monitors,omitempty"`
+
+	AccessLogs []AccessLog `json:"access_logs,omitempty"`
+
+	FileChecks []FileCheck `json:"file_checks,omitempty"`
+}
+
+// FileIntegrityConfig lists files and directory trees whose content, mode and
+// ownership are tracked across runs. MaxFiles and MaxBytes bound the work done
+// per run; zero means the built-in default.
+type FileIntegrityConfig struct {
+	Paths    []string `json:"paths"`
+	MaxFiles int      `json:"max_files,omitempty"`
+	MaxBytes int64    `json:"max_bytes,omitempty"`
+}
+
+// HTTPCheck declares a synthetic HTTP(S) request run on every send.
+// ExpectStatus zero accepts any 2xx or 3xx status; BodyPattern is a regular
+// expression matched against the first MiB of the body. Redirects are not
+// followed.
+type HTTPCheck struct {
+	Name           string            `json:"name"`
+	URL            string            `json:"url"`
+	Method         string            `json:"method,omitempty"`
+	Headers        map[string]string `json:"headers,omitempty"`
+	ExpectStatus   int               `json:"expect_status,omitempty"`
+	BodyPattern    string            `json:"body_pattern,omitempty"`
+	TimeoutSeconds int               `json:"timeout_seconds,omitempty"`
+}
+
+// TLSCertificatesConfig lists certificates whose expiry is monitored: live
+// endpoints, connected to with SNI, and PEM files given as paths or globs.
+type TLSCertificatesConfig struct {
+	Endpoints []TLSEndpoint `json:"endpoints,omitempty"`
+	Files     []string      `json:"files,omitempty"`
+}
+
+// TLSEndpoint is a host:port to connect to. ServerName overrides the SNI
+// and verification name, which default to the host part of Address.
+type TLSEndpoint struct {
+	Address        string `json:"address"`
+	ServerName     string `json:"server_name,omitempty"`
+	TimeoutSeconds int    `json:"timeout_seconds,omitempty"`
+}
+
+// PortCheck probes a TCP or UDP port. Send is written after connecting and
+// ExpectPattern, a regular expression, must match the first 4 KiB of the
+// response. Without ExpectPattern a TCP check only needs to connect and a UDP
+// check passes unless the port is reported unreachable.
+type PortCheck struct {
+	Name           string `json:"name,omitempty"`
+	Protocol       string `json:"protocol,omitempty"`
+	Address        string `json:"address"`
+	Send           string `json:"send,omitempty"`
+	ExpectPattern  string `json:"expect_pattern,omitempty"`
+	TimeoutSeconds int    `json:"timeout_seconds,omitempty"`
+}
+
+// DNSCheck resolves Query as record Type (default A) against Resolver, a
+// host or host:port, which defaults to the first nameserver in
+// /etc/resolv.conf. Every Expect value must be among the answers.
+type DNSCheck struct {
+	Name           string   `json:"name,omitempty"`
+	Query          string   `json:"query"`
+	Type           string   `json:"type,omitempty"`
+	Resolver       string   `json:"resolver,omitempty"`
+	Expect         []string `json:"expect,omitempty"`
+	TimeoutSeconds int      `json:"timeout_seconds,omitempty"`
+}
+
+// NagiosCheck runs a Nagios-compatible plugin. Command is the argv and is not
+// passed through a shell.
+type NagiosCheck struct {
+	Name           string   `json:"name"`
+	Command        []string `json:"command"`
+	TimeoutSeconds int      `json:"timeout_seconds,omitempty"`
+}
+
+// LogMonitor counts lines appended to a log file that match each rule.
+type LogMonitor struct {
+	Path  string    `json:"path"`
+	Rules []LogRule `json:"rules"`
+}
+
+// LogRule is a named regular expression. Severity is passed through to the
+// server as-is.
+type LogRule struct {
+	Name     string `json:"name"`
+	Pattern  string `json:"pattern"`
+	Severity string `json:"severity,omitempty"`
+}
+
+// AccessLog is a web server access log to analyse. Format is "combined"
+// (the default), "common" or a custom format in nginx log_format ($var) or
+// Apache LogFormat (%x) syntax.
+type AccessLog struct {
+	Path   string `json:"path"`
+	Format string `json:"format,omitempty"`
+}
+
+// FileCheck asserts the freshness, size or number of files. For a regular
+// file age and size are its own; for a directory they are those of the newest
+// file directly inside it matching Pattern (default all), and Count is the
+// number of such files. Zero limits are not checked.
+type FileCheck struct {
+	Name          string `json:"name,omitempty"`
+	Path          string `json:"path"`
+	Pattern       string `json:"pattern,omitempty"`
+	MaxAgeSeconds int64  `json:"max_age_seconds,omitempty"`
+	MinSizeBytes  int64  `json:"min_size_bytes,omitempty"`
+	MaxSizeBytes  int64  `json:"max_size_bytes,omitempty"`
+	MinCount      int    `json:"min_count,omitempty"`
+	MaxCount      int    `json:"max_count,omitempty"`
 }
 
 func Load(path string) (*Config, error) {
@@ -38,3 +188,31 @@ func Save(path string, cfg *Config) error {
 	content = append(content, '\n')
 	return os.WriteFile(path, content, 0o600)
 }
+
+const (
+	PostureKindSSHD         = "sshd_config"
+	PostureKindSysctl       = "sysctl"
+	PostureKindFileRegex    = "file_regex"
+	PostureKindPathWritable = "path_world_writable"
+)
+
+// PostureCheck declares a host-hardening check evaluated from local files.
+// Which fields apply depends on Kind:
+//   - sshd_config: Key must have one of the Expect values (Default when unset)
+//   - sysctl: the sysctl Key must have one of the Expect values
+//   - file_regex: a line of Path must match Pattern
+//   - path_world_writable: nothing in PATH may be world-writable
+//
+// A check whose AppliesIfExists path is missing is skipped.
+type PostureCheck struct {
+	ID              string   `json:"id"`
+	Description     string   `json:"description,omitempty"`
+	Kind            string   `json:"kind"`
+	Path            string   `json:"path,omitempty"`
+	Key             string   `json:"key,omitempty"`
+	Expect          []string `json:"expect,omitempty"`
+	Default         string   `json:"default,omitempty"`
+	Pattern         string   `json:"pattern,omitempty"`
+	AppliesIfExists string   `json:"applies_if_exists,omitempty"`
+	Disabled        bool     `json:"disabled,omitempty"`
+}
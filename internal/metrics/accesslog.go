@@ -0,0 +1,373 @@
+package metrics
+
+import (
+	"fmt"
+	"math"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/MightyToolkit/mightymonitor-agent/internal/config"
+)
+
+const (
+	maxAccessLogBytesPerRun = 64 << 20
+	maxTop5xxPaths          = 10
+	maxTracked5xxPaths      = 10000
+
+	accessLogTimeLayout = "02/Jan/2006:15:04:05 -0700"
+
+	accessLogFormatCommon   = `$remote_addr - $remote_user [$time_local] "$request" $status $body_bytes_sent`
+	accessLogFormatCombined = accessLogFormatCommon + ` "$http_referer" "$http_user_agent"`
+)
+
+// apacheDirectives maps Apache LogFormat directives to the nginx variable
+// with the same meaning, so both syntaxes share one parser.
+var apacheDirectives = map[string]string{
+	"h":  "remote_addr",
+	"a":  "remote_addr",
+	"u":  "remote_user",
+	"t":  "time_local",
+	"r":  "request",
+	"s":  "status",
+	">s": "status",
+	"U":  "uri",
+	"D":  "request_time_us",
+	"T":  "request_time",
+}
+
+// accessLogParser is a compiled log format. Fields maps the variables the
+// analysis uses to their capture group.
+type accessLogParser struct {
+	pattern *regexp.Regexp
+	fields  map[string]int
+}
+
+// compileAccessLogFormat turns a log format into an anchored regular
+// expression. Each variable matches up to the next literal character of the
+// format; quoted fields allow backslash escapes. Extra text after the format
+// is ignored so extended formats still parse.
+func compileAccessLogFormat(format string) (*accessLogParser, error) {
+	switch format {
+	case "", "combined":
+		format = accessLogFormatCombined
+	case "common":
+		format = accessLogFormatCommon
+	}
+
+	// Variables not used by the analysis, including unknown Apache
+	// directives, still get a group but an empty name.
+	type token struct {
+		literal    string
+		isVariable bool
+		variable   string
+		bracketed  bool
+	}
+	var tokens []token
+	var literal strings.Builder
+	flush := func() {
+		if literal.Len() > 0 {
+			tokens = append(tokens, token{literal: literal.String()})
+			literal.Reset()
+		}
+	}
+	for i := 0; i < len(format); i++ {
+		c := format[i]
+		switch {
+		case c == '$' && i+1 < len(format) && isFormatNameByte(format[i+1]):
+			j := i + 1
+			for j < len(format) && isFormatNameByte(format[j]) {
+				j++
+			}
+			flush()
+			tokens = append(tokens, token{isVariable: true, variable: format[i+1 : j]})
+			i = j - 1
+		case c == '%' && i+1 < len(format) && format[i+1] == '%':
+			literal.WriteByte('%')
+			i++
+		case c == '%' && i+1 < len(format):
+			j := i + 1
+			if format[j] == '>' || format[j] == '<' {
+				j++
+			}
+			if j < len(format) && format[j] == '{' {
+				end := strings.IndexByte(format[j:], '}')
+				if end < 0 {
+					return nil, fmt.Errorf("unterminated %%{ in format")
+				}
+				j += end + 1
+			}
+			if j >= len(format) {
+				return nil, fmt.Errorf("incomplete directive at end of format")
+			}
+			directive := format[i+1 : j+1]
+			flush()
+			// Apache's %t includes the surrounding brackets.
+			tokens = append(tokens, token{
+				isVariable: true,
+				variable:   apacheDirectives[strings.TrimPrefix(directive, "<")],
+				bracketed:  directive == "t",
+			})
+			i = j
+		case c == '\\' && i+1 < len(format):
+			literal.WriteByte(format[i+1])
+			i++
+		default:
+			literal.WriteByte(c)
+		}
+	}
+	flush()
+
+	parser := &accessLogParser{fields: map[string]int{}}
+	var expr strings.Builder
+	expr.WriteString("^")
+	group := 0
+	for i, tok := range tokens {
+		if !tok.isVariable {
+			expr.WriteString(regexp.QuoteMeta(tok.literal))
+			continue
+		}
+		var next byte
+		if i+1 < len(tokens) && !tokens[i+1].isVariable {
+			next = tokens[i+1].literal[0]
+		}
+		var sub string
+		switch {
+		case tok.bracketed:
+			sub = `\[[^\]]*\]`
+		case next == '"':
+			sub = `(?:[^"\\]|\\.)*`
+		case next != 0:
+			sub = `[^` + regexp.QuoteMeta(string(next)) + `]*`
+		default:
+			sub = `\S*`
+		}
+		group++
+		expr.WriteString("(" + sub + ")")
+		if tok.variable != "" {
+			if _, ok := parser.fields[tok.variable]; !ok {
+				parser.fields[tok.variable] = group
+			}
+		}
+	}
+
+	pattern, err := regexp.Compile(expr.String())
+	if err != nil {
+		return nil, err
+	}
+	parser.pattern = pattern
+	if _, ok := parser.fields["status"]; !ok {
+		return nil, fmt.Errorf("format has no status field")
+	}
+	return parser, nil
+}
+
+func isFormatNameByte(c byte) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9')
+}
+
+func (p *accessLogParser) field(match []string, name string) string {
+	if group, ok := p.fields[name]; ok {
+		return match[group]
+	}
+	return ""
+}
+
+type accessLogStats struct {
+	requests   int
+	unparsed   int
+	statuses   AccessLogStatusPayload
+	requestMs  []float64
+	upstreamMs []float64
+	paths5xx   map[string]int
+	// first and last are the earliest and latest request times seen, when
+	// the format logs them.
+	first time.Time
+	last  time.Time
+}
+
+func (s *accessLogStats) add(parser *accessLogParser, line string) {
+	match := parser.pattern.FindStringSubmatch(line)
+	if match == nil {
+		s.unparsed++
+		return
+	}
+	status, err := strconv.Atoi(parser.field(match, "status"))
+	if err != nil {
+		s.unparsed++
+		return
+	}
+	s.requests++
+	if ts, err := time.Parse(accessLogTimeLayout, strings.Trim(parser.field(match, "time_local"), "[]")); err == nil {
+		if s.first.IsZero() || ts.Before(s.first) {
+			s.first = ts
+		}
+		if ts.After(s.last) {
+			s.last = ts
+		}
+	}
+	switch status / 100 {
+	case 1:
+		s.statuses.Status1xx++
+	case 2:
+		s.statuses.Status2xx++
+	case 3:
+		s.statuses.Status3xx++
+	case 4:
+		s.statuses.Status4xx++
+	case 5:
+		s.statuses.Status5xx++
+		path := requestPath(parser.field(match, "request"), parser.field(match, "uri"))
+		if _, ok := s.paths5xx[path]; ok || len(s.paths5xx) < maxTracked5xxPaths {
+			s.paths5xx[path]++
+		}
+	}
+
+	if value, err := strconv.ParseFloat(parser.field(match, "request_time"), 64); err == nil {
+		s.requestMs = append(s.requestMs, value*1000)
+	} else if value, err := strconv.ParseFloat(parser.field(match, "request_time_us"), 64); err == nil {
+		s.requestMs = append(s.requestMs, value/1000)
+	}
+	if value, ok := upstreamSeconds(parser.field(match, "upstream_response_time")); ok {
+		s.upstreamMs = append(s.upstreamMs, value*1000)
+	}
+}
+
+// requestPath returns the path of a "METHOD /path?query PROTO" request line,
+// or uri when the format logs it separately.
+func requestPath(request string, uri string) string {
+	if uri == "" {
+		fields := strings.Fields(request)
+		if len(fields) < 2 {
+			return "-"
+		}
+		uri = fields[1]
+	}
+	path, _, _ := strings.Cut(uri, "?")
+	return path
+}
+
+// upstreamSeconds sums nginx $upstream_response_time, which lists one time
+// per upstream tried, separated by ", " or " : ", with "-" for none.
+func upstreamSeconds(value string) (float64, bool) {
+	total := 0.0
+	found := false
+	for _, part := range strings.FieldsFunc(value, func(r rune) bool { return r == ',' || r == ':' || r == ' ' }) {
+		seconds, err := strconv.ParseFloat(part, 64)
+		if err != nil {
+			continue
+		}
+		total += seconds
+		found = true
+	}
+	return total, found
+}
+
+// percentiles uses the nearest-rank method.
+func percentiles(values []float64) *PercentilesPayload {
+	if len(values) == 0 {
+		return nil
+	}
+	sort.Float64s(values)
+	rank := func(p float64) float64 {
+		index := int(math.Ceil(p*float64(len(values)))) - 1
+		return math.Round(values[max(index, 0)]*1000) / 1000
+	}
+	return &PercentilesPayload{P50: rank(0.50), P95: rank(0.95), P99: rank(0.99)}
+}
+
+// CollectAccessLogs analyses the requests appended to the configured access
+// logs since the last committed read positions, staging the new ones in
+// pending like CollectLogMonitors. When more than maxAccessLogBytesPerRun was
+// appended the result is marked partial and the request rate is taken from
+// the time span of the requests read, since they cover less than the window.
+func CollectAccessLogs(stateDir string, logs []config.AccessLog, pending *PendingState) ([]AccessLogPayload, error) {
+	if len(logs) == 0 {
+		return nil, nil
+	}
+
+	stateFile := filepath.Join(stateDir, "access_logs_state.json")
+	var prev tailState
+	hasPrev := loadState(stateFile, &prev) == nil
+
+	now := time.Now().Unix()
+	current := tailState{TS: now, Files: make(map[string]fileOffset, len(logs))}
+	var window int64
+	if hasPrev && prev.TS > 0 {
+		window = now - prev.TS
+	}
+
+	results := make([]AccessLogPayload, 0, len(logs))
+	for _, accessLog := range logs {
+		result := AccessLogPayload{Path: accessLog.Path}
+		var prevOffset *fileOffset
+		if offset, ok := prev.Files[accessLog.Path]; ok {
+			prevOffset = &offset
+		}
+
+		parser, err := compileAccessLogFormat(accessLog.Format)
+		if err != nil {
+			result.Error = fmt.Sprintf("invalid format: %v", err)
+			if prevOffset != nil {
+				current.Files[accessLog.Path] = *prevOffset
+			}
+			results = append(results, result)
+			continue
+		}
+
+		stats := &accessLogStats{paths5xx: map[string]int{}}
+		offset, limited, err := readNewLines(accessLog.Path, prevOffset, maxAccessLogBytesPerRun, func(line string) {
+			stats.add(parser, line)
+		})
+		if err != nil {
+			result.Error = err.Error()
+			if prevOffset != nil {
+				current.Files[accessLog.Path] = *prevOffset
+			}
+			results = append(results, result)
+			continue
+		}
+		current.Files[accessLog.Path] = offset
+
+		result.Requests = stats.requests
+		result.Unparsed = stats.unparsed
+		result.StatusClasses = stats.statuses
+		result.RequestTimeMs = percentiles(stats.requestMs)
+		result.UpstreamTimeMs = percentiles(stats.upstreamMs)
+		result.Top5xxPaths = topPathCounts(stats.paths5xx, maxTop5xxPaths)
+		result.Partial = limited
+		elapsed := window
+		if limited {
+			elapsed = int64(stats.last.Sub(stats.first).Seconds())
+		}
+		if prevOffset != nil && elapsed > 0 {
+			result.WindowSeconds = &elapsed
+			rate := float64(stats.requests) / float64(elapsed)
+			result.RequestsPerSec = &rate
+		}
+		results = append(results, result)
+	}
+
+	pending.stage(stateFile, current)
+	return results, nil
+}
+
+func topPathCounts(counts map[string]int, limit int) []AccessLogPathCountPayload {
+	result := make([]AccessLogPathCountPayload, 0, len(counts))
+	for path, count := range counts {
+		result = append(result, AccessLogPathCountPayload{Path: path, Count: count})
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Count != result[j].Count {
+			return result[i].Count > result[j].Count
+		}
+		return result[i].Path < result[j].Path
+	})
+	if len(result) > limit {
+		result = result[:limit]
+	}
+	return result
+}
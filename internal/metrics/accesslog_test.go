@@ -0,0 +1,63 @@
+package metrics
+
+import (
+	"testing"
+	"time"
+)
+
+func TestAccessLogStatsTimeSpan(t *testing.T) {
+	tests := []struct {
+		name   string
+		format string
+		lines  []string
+	}{
+		{
+			name:   "nginx combined",
+			format: "combined",
+			lines: []string{
+				`192.0.2.1 - - [18/Oct/2026:10:00:05 +0200] "GET / HTTP/1.1" 200 612 "-" "curl/8.5"`,
+				`192.0.2.1 - - [18/Oct/2026:10:00:00 +0200] "GET /a HTTP/1.1" 404 10 "-" "curl/8.5"`,
+				`192.0.2.1 - - [18/Oct/2026:10:01:40 +0200] "GET /b HTTP/1.1" 500 10 "-" "curl/8.5"`,
+			},
+		},
+		{
+			name:   "apache %t",
+			format: `%h %l %u %t "%r" %>s %b`,
+			lines: []string{
+				`192.0.2.1 - - [18/Oct/2026:10:00:00 +0200] "GET / HTTP/1.1" 200 612`,
+				`192.0.2.1 - - [18/Oct/2026:10:01:40 +0200] "GET /b HTTP/1.1" 500 10`,
+			},
+		},
+	}
+	first := time.Date(2026, time.October, 18, 8, 0, 0, 0, time.UTC)
+	last := first.Add(100 * time.Second)
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			parser, err := compileAccessLogFormat(tt.format)
+			if err != nil {
+				t.Fatalf("compileAccessLogFormat: %v", err)
+			}
+			stats := &accessLogStats{paths5xx: map[string]int{}}
+			for _, line := range tt.lines {
+				stats.add(parser, line)
+			}
+			if stats.requests != len(tt.lines) {
+				t.Errorf("requests = %d, want %d", stats.requests, len(tt.lines))
+			}
+			if !stats.first.Equal(first) || !stats.last.Equal(last) {
+				t.Errorf("span = %v to %v, want %v to %v", stats.first, stats.last, first, last)
+			}
+		})
+	}
+
+	parser, err := compileAccessLogFormat(`$remote_addr "$request" $status`)
+	if err != nil {
+		t.Fatalf("compileAccessLogFormat: %v", err)
+	}
+	stats := &accessLogStats{paths5xx: map[string]int{}}
+	stats.add(parser, `192.0.2.1 "GET / HTTP/1.1" 200`)
+	if stats.requests != 1 || !stats.first.IsZero() || !stats.last.IsZero() {
+		t.Errorf("format without time: requests %d, span %v to %v", stats.requests, stats.first, stats.last)
+	}
+}
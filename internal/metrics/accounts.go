@@ -0,0 +1,360 @@
+package metrics
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var privilegedGroups = []string{"sudo", "wheel", "admin"}
+
+type accountEntry struct {
+	UID   int    `json:"uid"`
+	GID   int    `json:"gid"`
+	Home  string `json:"home"`
+	Shell string `json:"shell"`
+}
+
+type authorizedKey struct {
+	Type    string `json:"type"`
+	Comment string `json:"comment,omitempty"`
+}
+
+type accountsState struct {
+	Users          map[string]accountEntry             `json:"users"`
+	SudoGrants     []string                            `json:"sudoGrants"`
+	AuthorizedKeys map[string]map[string]authorizedKey `json:"authorizedKeys"`
+}
+
+// CollectAccountChanges snapshots local accounts, sudo grants and the
+// authorized_keys of keyUsers, and returns events describing what changed
+// since the last committed snapshot, staging the new one in pending. The
+// first call only records the baseline, except that non-root UID 0 accounts
+// are always reported when first seen.
+func CollectAccountChanges(stateDir string, keyUsers []string, pending *PendingState) ([]EventPayload, error) {
+	users, err := readPasswd("/etc/passwd")
+	if err != nil {
+		return nil, err
+	}
+	groups, err := readGroupMembers("/etc/group", users)
+	if err != nil {
+		return nil, err
+	}
+
+	current := accountsState{
+		Users:          users,
+		SudoGrants:     readSudoGrants(groups),
+		AuthorizedKeys: map[string]map[string]authorizedKey{},
+	}
+	for _, user := range keyUsers {
+		entry, ok := users[user]
+		if !ok {
+			continue
+		}
+		current.AuthorizedKeys[user] = readAuthorizedKeys(entry.Home)
+	}
+
+	stateFile := filepath.Join(stateDir, "accounts_state.json")
+	var prev accountsState
+	prevErr := loadState(stateFile, &prev)
+	pending.stage(stateFile, current)
+
+	now := time.Now().Unix()
+	var events []EventPayload
+	if prevErr != nil {
+		// Without a baseline every account would look new; only flag the
+		// accounts that are suspicious on their own.
+		for _, name := range sortedKeys(users) {
+			if users[name].UID == 0 && name != "root" {
+				events = append(events, uid0Event(now, name))
+			}
+		}
+		return events, nil
+	}
+
+	for _, name := range sortedKeys(users) {
+		entry := users[name]
+		old, existed := prev.Users[name]
+		if !existed {
+			events = append(events, EventPayload{
+				Type:    "account_added",
+				TS:      now,
+				Message: fmt.Sprintf("account %s added (uid %d)", name, entry.UID),
+				Details: map[string]any{"user": name, "uid": entry.UID, "gid": entry.GID, "home": entry.Home, "shell": entry.Shell},
+			})
+		}
+		if entry.UID == 0 && name != "root" && (!existed || old.UID != 0) {
+			events = append(events, uid0Event(now, name))
+		}
+	}
+	for _, name := range sortedKeys(prev.Users) {
+		if _, ok := users[name]; !ok {
+			events = append(events, EventPayload{
+				Type:    "account_removed",
+				TS:      now,
+				Message: fmt.Sprintf("account %s removed", name),
+				Details: map[string]any{"user": name, "uid": prev.Users[name].UID},
+			})
+		}
+	}
+
+	added, removed := diffStrings(prev.SudoGrants, current.SudoGrants)
+	for _, grant := range added {
+		events = append(events, EventPayload{
+			Type:    "sudo_grant_added",
+			TS:      now,
+			Message: "sudo grant added: " + grant,
+			Details: map[string]any{"grant": grant},
+		})
+	}
+	for _, grant := range removed {
+		events = append(events, EventPayload{
+			Type:    "sudo_grant_removed",
+			TS:      now,
+			Message: "sudo grant removed: " + grant,
+			Details: map[string]any{"grant": grant},
+		})
+	}
+
+	for _, user := range sortedKeys(current.AuthorizedKeys) {
+		prevKeys, tracked := prev.AuthorizedKeys[user]
+		if !tracked {
+			// User was added to the watch list; start from its current keys.
+			continue
+		}
+		keys := current.AuthorizedKeys[user]
+		for _, fingerprint := range sortedKeys(keys) {
+			if _, ok := prevKeys[fingerprint]; !ok {
+				events = append(events, authorizedKeyEvent("authorized_key_added", now, user, fingerprint, keys[fingerprint]))
+			}
+		}
+		for _, fingerprint := range sortedKeys(prevKeys) {
+			if _, ok := keys[fingerprint]; !ok {
+				events = append(events, authorizedKeyEvent("authorized_key_removed", now, user, fingerprint, prevKeys[fingerprint]))
+			}
+		}
+	}
+	return events, nil
+}
+
+func uid0Event(now int64, name string) EventPayload {
+	return EventPayload{
+		Type:    "uid0_account",
+		TS:      now,
+		Message: fmt.Sprintf("account %s has UID 0", name),
+		Details: map[string]any{"user": name},
+	}
+}
+
+func authorizedKeyEvent(eventType string, now int64, user string, fingerprint string, key authorizedKey) EventPayload {
+	verb := "added to"
+	if eventType == "authorized_key_removed" {
+		verb = "removed from"
+	}
+	return EventPayload{
+		Type:    eventType,
+		TS:      now,
+		Message: fmt.Sprintf("%s key %s %s authorized_keys of %s", key.Type, fingerprint, verb, user),
+		Details: map[string]any{"user": user, "fingerprint": fingerprint, "keyType": key.Type, "comment": key.Comment},
+	}
+}
+
+func readPasswd(path string) (map[string]accountEntry, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	users := map[string]accountEntry{}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Split(scanner.Text(), ":")
+		if len(fields) < 7 || fields[0] == "" || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		uid, err := strconv.Atoi(fields[2])
+		if err != nil {
+			continue
+		}
+		gid, _ := strconv.Atoi(fields[3])
+		users[fields[0]] = accountEntry{UID: uid, GID: gid, Home: fields[5], Shell: fields[6]}
+	}
+	return users, scanner.Err()
+}
+
+// readGroupMembers returns the members of the privileged groups, including
+// users whose primary group it is.
+func readGroupMembers(path string, users map[string]accountEntry) (map[string][]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	members := map[string][]string{}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Split(scanner.Text(), ":")
+		if len(fields) < 4 || !slices.Contains(privilegedGroups, fields[0]) {
+			continue
+		}
+		gid, err := strconv.Atoi(fields[2])
+		if err != nil {
+			continue
+		}
+
+		seen := map[string]bool{}
+		for _, member := range strings.Split(fields[3], ",") {
+			if member = strings.TrimSpace(member); member != "" {
+				seen[member] = true
+			}
+		}
+		for name, entry := range users {
+			if entry.GID == gid {
+				seen[name] = true
+			}
+		}
+		members[fields[0]] = sortedKeys(seen)
+	}
+	return members, scanner.Err()
+}
+
+// readSudoGrants returns the normalized privilege rules from /etc/sudoers and
+// /etc/sudoers.d plus membership of the groups that conventionally grant
+// sudo. Defaults and alias definitions are not grants and are skipped.
+func readSudoGrants(groupMembers map[string][]string) []string {
+	files := []string{"/etc/sudoers"}
+	if entries, err := os.ReadDir("/etc/sudoers.d"); err == nil {
+		for _, entry := range entries {
+			name := entry.Name()
+			// sudo ignores files ending in ~ or containing a dot.
+			if entry.IsDir() || strings.HasSuffix(name, "~") || strings.Contains(name, ".") {
+				continue
+			}
+			files = append(files, filepath.Join("/etc/sudoers.d", name))
+		}
+	}
+
+	var grants []string
+	for _, path := range files {
+		content, err := os.ReadFile(path)
+		if err != nil {
+			continue
+		}
+		for _, rule := range parseSudoersRules(string(content)) {
+			grants = append(grants, filepath.Base(path)+": "+rule)
+		}
+	}
+	for group, members := range groupMembers {
+		for _, member := range members {
+			grants = append(grants, fmt.Sprintf("group %s: %s", group, member))
+		}
+	}
+	sort.Strings(grants)
+	return grants
+}
+
+func parseSudoersRules(content string) []string {
+	var rules []string
+	var pending strings.Builder
+	for _, line := range strings.Split(content, "\n") {
+		line = strings.TrimSpace(line)
+		if strings.HasSuffix(line, "\\") {
+			pending.WriteString(strings.TrimSuffix(line, "\\"))
+			pending.WriteByte(' ')
+			continue
+		}
+		pending.WriteString(line)
+		rule := strings.Join(strings.Fields(pending.String()), " ")
+		pending.Reset()
+
+		if i := strings.Index(rule, "#"); i >= 0 {
+			rule = strings.TrimSpace(rule[:i])
+		}
+		if rule == "" || strings.HasPrefix(rule, "@include") || strings.HasPrefix(rule, "Defaults") {
+			continue
+		}
+		if keyword, _, _ := strings.Cut(rule, " "); strings.HasSuffix(keyword, "_Alias") {
+			continue
+		}
+		rules = append(rules, rule)
+	}
+	return rules
+}
+
+// readAuthorizedKeys returns the keys in ~/.ssh/authorized_keys keyed by
+// their OpenSSH SHA256 fingerprint.
+func readAuthorizedKeys(home string) map[string]authorizedKey {
+	keys := map[string]authorizedKey{}
+	content, err := os.ReadFile(filepath.Join(home, ".ssh", "authorized_keys"))
+	if err != nil {
+		return keys
+	}
+
+	for _, line := range strings.Split(string(content), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		// Lines may start with options; the key type is the first field
+		// that looks like one and the base64 blob follows it.
+		for i := 0; i+1 < len(fields); i++ {
+			if !isSSHKeyType(fields[i]) {
+				continue
+			}
+			blob, err := base64.StdEncoding.DecodeString(fields[i+1])
+			if err != nil {
+				break
+			}
+			sum := sha256.Sum256(blob)
+			fingerprint := "SHA256:" + base64.RawStdEncoding.EncodeToString(sum[:])
+			keys[fingerprint] = authorizedKey{Type: fields[i], Comment: strings.Join(fields[i+2:], " ")}
+			break
+		}
+	}
+	return keys
+}
+
+func isSSHKeyType(field string) bool {
+	return strings.HasPrefix(field, "ssh-") || strings.HasPrefix(field, "ecdsa-sha2-") || strings.HasPrefix(field, "sk-")
+}
+
+// diffStrings returns the values only in current and only in prev, in the
+// order they appear in their input.
+func diffStrings(prev []string, current []string) (added []string, removed []string) {
+	prevSet := make(map[string]bool, len(prev))
+	for _, value := range prev {
+		prevSet[value] = true
+	}
+	currentSet := make(map[string]bool, len(current))
+	for _, value := range current {
+		currentSet[value] = true
+		if !prevSet[value] {
+			added = append(added, value)
+		}
+	}
+	for _, value := range prev {
+		if !currentSet[value] {
+			removed = append(removed, value)
+		}
+	}
+	return added, removed
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
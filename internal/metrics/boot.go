@@ -0,0 +1,75 @@
+package metrics
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"time"
+)
+
+type bootState struct {
+	BootID        string `json:"bootId"`
+	UptimeSeconds int64  `json:"uptimeSeconds"`
+	Timestamp     int64  `json:"timestamp"`
+	// Unreported holds reboot events whose payload has not been committed
+	// as accepted yet.
+	Unreported []EventPayload `json:"unreported,omitempty"`
+}
+
+// DetectReboot compares the kernel boot_id with the one persisted on the
+// previous call and returns a "host_rebooted" event when it changed. The
+// previous uptime is the last one observed before the reboot, and the
+// downtime estimate is the gap between that observation and the new boot
+// time, so both are lower bounds. Returns nil on first run.
+//
+// The observation is saved right away to keep the estimates tight, while
+// the events are kept in the state and returned again on every call until
+// the state staged in pending is committed.
+func DetectReboot(stateDir string, now time.Time, pending *PendingState) ([]EventPayload, error) {
+	bootID := readTrimmedFile("/proc/sys/kernel/random/boot_id")
+	if bootID == "" {
+		return nil, errors.New("boot_id not available")
+	}
+	uptime, err := GetUptime()
+	if err != nil {
+		return nil, err
+	}
+
+	stateFile := filepath.Join(stateDir, "boot_state.json")
+	var prev bootState
+	prevErr := loadState(stateFile, &prev)
+
+	current := bootState{BootID: bootID, UptimeSeconds: *uptime, Timestamp: now.Unix(), Unreported: prev.Unreported}
+	if prevErr == nil && prev.BootID != "" && prev.BootID != bootID {
+		current.Unreported = append(current.Unreported, rebootEvent(prev, bootID, *uptime, now))
+	}
+	if err := saveState(stateFile, current); err != nil {
+		return nil, fmt.Errorf("save boot state: %w", err)
+	}
+
+	reported := current
+	reported.Unreported = nil
+	pending.stage(stateFile, reported)
+	return current.Unreported, nil
+}
+
+func rebootEvent(prev bootState, bootID string, uptime int64, now time.Time) EventPayload {
+	bootedAt := now.Unix() - uptime
+	downtime := bootedAt - prev.Timestamp
+	if downtime < 0 {
+		downtime = 0
+	}
+
+	return EventPayload{
+		Type:    "host_rebooted",
+		TS:      now.Unix(),
+		Message: fmt.Sprintf("host rebooted after %s uptime, down for about %s", time.Duration(prev.UptimeSeconds)*time.Second, time.Duration(downtime)*time.Second),
+		Details: map[string]any{
+			"previousBootId":           prev.BootID,
+			"bootId":                   bootID,
+			"previousUptimeSeconds":    prev.UptimeSeconds,
+			"estimatedDowntimeSeconds": downtime,
+			"bootedAt":                 bootedAt,
+		},
+	}
+}
@@ -0,0 +1,472 @@
+package metrics
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// cgroupRoot is the cgroup v2 unified hierarchy mount point.
+var cgroupRoot = "/sys/fs/cgroup"
+
+const maxCgroupWalkDepth = 8
+
+// ErrCgroupV1 is returned by the cgroup collectors on hosts without the
+// unified hierarchy.
+var ErrCgroupV1 = errors.New("cgroup v2 unified hierarchy not mounted")
+
+// containerScopePattern matches the leaf cgroups container runtimes create
+// under the systemd cgroup driver. conmon scopes are podman/cri-o monitor
+// processes, not containers.
+var containerScopePattern = regexp.MustCompile(`^(docker|libpod|cri-containerd|crio|nerdctl)-([0-9a-f]{12,64})\.scope$`)
+
+var containerIDPattern = regexp.MustCompile(`^[0-9a-f]{64}$`)
+
+var podUIDPattern = regexp.MustCompile(`pod([0-9a-f_-]{36})\.slice$`)
+
+var containerRuntimes = map[string]string{
+	"docker":         "docker",
+	"libpod":         "podman",
+	"cri-containerd": "containerd",
+	"crio":           "cri-o",
+	"nerdctl":        "containerd",
+}
+
+// cgroupCounters are the monotonically increasing values rates are derived
+// from.
+type cgroupCounters struct {
+	CPUUsageUsec uint64 `json:"cpuUsageUsec"`
+	IOReadBytes  uint64 `json:"ioReadBytes"`
+	IOWriteBytes uint64 `json:"ioWriteBytes"`
+	OOMKills     uint64 `json:"oomKills"`
+}
+
+type cgroupSample struct {
+	Counters      cgroupCounters
+	MemoryCurrent int64
+	MemoryMax     *int64
+	Tasks         *int64
+	OOM           uint64
+	Pressure      *PressurePayload
+}
+
+type cgroupState struct {
+	Timestamp int64                     `json:"timestamp"`
+	Groups    map[string]cgroupCounters `json:"groups"`
+}
+
+type containerCgroup struct {
+	ID      string
+	Runtime string
+	PodUID  string
+	Path    string
+}
+
+// CollectContainers walks the cgroup v2 hierarchy for container cgroups
+// created by Docker, Podman, containerd, CRI-O and Kubernetes and reports
+// their resource usage. CPU and I/O rates are derived from counters persisted
+// in the state directory and are omitted on first sight of a container.
+func CollectContainers(stateDir string) ([]ContainerResourcesPayload, error) {
+	if !isCgroupV2() {
+		return nil, ErrCgroupV1
+	}
+
+	containers, err := findContainerCgroups()
+	if err != nil {
+		return nil, err
+	}
+
+	groups := make(map[string]string, len(containers))
+	for _, container := range containers {
+		groups[container.Path] = filepath.Join(cgroupRoot, container.Path)
+	}
+	samples, rates, err := sampleCgroups(filepath.Join(stateDir, "containers_state.json"), groups)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]ContainerResourcesPayload, 0, len(containers))
+	for _, container := range containers {
+		sample, ok := samples[container.Path]
+		if !ok {
+			continue
+		}
+		payload := ContainerResourcesPayload{
+			ID:         container.ID,
+			Runtime:    container.Runtime,
+			PodUID:     container.PodUID,
+			CgroupPath: container.Path,
+		}
+		applyCgroupSample(&payload.CgroupResourcesPayload, sample, rates[container.Path])
+		result = append(result, payload)
+	}
+	return result, nil
+}
+
+func isCgroupV2() bool {
+	_, err := os.Stat(filepath.Join(cgroupRoot, "cgroup.controllers"))
+	return err == nil
+}
+
+// findContainerCgroups returns container cgroups relative to cgroupRoot,
+// sorted by path. Container cgroups are not descended into, so nested
+// cgroups of a container are accounted to it.
+func findContainerCgroups() ([]containerCgroup, error) {
+	var containers []containerCgroup
+	err := filepath.WalkDir(cgroupRoot, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			if entry != nil && entry.IsDir() && path != cgroupRoot {
+				return fs.SkipDir
+			}
+			return err
+		}
+		if !entry.IsDir() || path == cgroupRoot {
+			return nil
+		}
+
+		rel, err := filepath.Rel(cgroupRoot, path)
+		if err != nil {
+			return err
+		}
+		if strings.Count(rel, string(filepath.Separator)) >= maxCgroupWalkDepth {
+			return fs.SkipDir
+		}
+
+		container, ok := parseContainerCgroup(rel)
+		if !ok {
+			return nil
+		}
+		containers = append(containers, container)
+		return fs.SkipDir
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(containers, func(i, j int) bool { return containers[i].Path < containers[j].Path })
+	return containers, nil
+}
+
+// parseContainerCgroup recognizes systemd-driver scopes such as
+// system.slice/docker-<id>.scope and cgroupfs-driver directories such as
+// docker/<id> or kubepods/burstable/pod<uid>/<id>.
+func parseContainerCgroup(rel string) (containerCgroup, bool) {
+	name := filepath.Base(rel)
+	parent := filepath.Dir(rel)
+	container := containerCgroup{Path: rel}
+
+	if match := containerScopePattern.FindStringSubmatch(name); match != nil {
+		container.Runtime = containerRuntimes[match[1]]
+		container.ID = match[2]
+	} else if containerIDPattern.MatchString(name) {
+		switch {
+		case strings.HasPrefix(rel, "docker/"):
+			container.Runtime = "docker"
+		case strings.HasPrefix(rel, "kubepods"):
+			container.Runtime = "kubernetes"
+		case strings.HasPrefix(rel, "machine.slice/libpod"), strings.HasPrefix(rel, "libpod_parent/"):
+			container.Runtime = "podman"
+		default:
+			return containerCgroup{}, false
+		}
+		container.ID = name
+	} else {
+		return containerCgroup{}, false
+	}
+
+	if strings.Contains(rel, "kubepods") {
+		parentName := filepath.Base(parent)
+		if match := podUIDPattern.FindStringSubmatch(parentName); match != nil {
+			container.PodUID = strings.ReplaceAll(match[1], "_", "-")
+		} else if strings.HasPrefix(parentName, "pod") {
+			container.PodUID = strings.TrimPrefix(parentName, "pod")
+		}
+	}
+	if len(container.ID) > 12 {
+		container.ID = container.ID[:12]
+	}
+	return container, true
+}
+
+// sampleCgroups reads every cgroup in groups (key to absolute directory),
+// persists their counters in stateFile and returns per-key rates for groups
+// that were also present in the previous sample.
+func sampleCgroups(stateFile string, groups map[string]string) (map[string]cgroupSample, map[string]*cgroupRates, error) {
+	now := time.Now().Unix()
+	samples := make(map[string]cgroupSample, len(groups))
+	current := cgroupState{Timestamp: now, Groups: make(map[string]cgroupCounters, len(groups))}
+	for key, dir := range groups {
+		sample, err := readCgroup(dir)
+		if err != nil {
+			// The cgroup disappeared between discovery and reading.
+			continue
+		}
+		samples[key] = sample
+		current.Groups[key] = sample.Counters
+	}
+
+	var prev cgroupState
+	prevErr := loadState(stateFile, &prev)
+	if err := saveState(stateFile, current); err != nil {
+		return nil, nil, fmt.Errorf("save cgroup state: %w", err)
+	}
+
+	rates := map[string]*cgroupRates{}
+	elapsed := float64(now - prev.Timestamp)
+	if prevErr != nil || elapsed <= 0 || elapsed > 300 {
+		return samples, rates, nil
+	}
+	for key, sample := range samples {
+		old, ok := prev.Groups[key]
+		if !ok {
+			continue
+		}
+		cur := sample.Counters
+		// Counter reset detection (cgroup recreated with the same name)
+		if cur.CPUUsageUsec < old.CPUUsageUsec || cur.IOReadBytes < old.IOReadBytes ||
+			cur.IOWriteBytes < old.IOWriteBytes || cur.OOMKills < old.OOMKills {
+			continue
+		}
+		rates[key] = &cgroupRates{
+			CPUPercent:         float64(cur.CPUUsageUsec-old.CPUUsageUsec) / (elapsed * 1e6) * 100,
+			IOReadBytesPerSec:  float64(cur.IOReadBytes-old.IOReadBytes) / elapsed,
+			IOWriteBytesPerSec: float64(cur.IOWriteBytes-old.IOWriteBytes) / elapsed,
+			NewOOMKills:        cur.OOMKills - old.OOMKills,
+		}
+	}
+	return samples, rates, nil
+}
+
+type cgroupRates struct {
+	CPUPercent         float64
+	IOReadBytesPerSec  float64
+	IOWriteBytesPerSec float64
+	NewOOMKills        uint64
+}
+
+func applyCgroupSample(payload *CgroupResourcesPayload, sample cgroupSample, rates *cgroupRates) {
+	payload.MemoryCurrentBytes = sample.MemoryCurrent
+	payload.MemoryMaxBytes = sample.MemoryMax
+	payload.Tasks = sample.Tasks
+	payload.OOMEvents = sample.OOM
+	payload.OOMKills = sample.Counters.OOMKills
+	payload.Pressure = sample.Pressure
+	if rates != nil {
+		cpu := rates.CPUPercent
+		read := rates.IOReadBytesPerSec
+		write := rates.IOWriteBytesPerSec
+		newOOMKills := rates.NewOOMKills
+		payload.CPUPercent = &cpu
+		payload.IOReadBytesPerSec = &read
+		payload.IOWriteBytesPerSec = &write
+		payload.NewOOMKills = &newOOMKills
+	}
+}
+
+// readCgroup reads the cgroup v2 interface files of dir. Files of disabled
+// controllers are simply absent and leave their fields zero.
+func readCgroup(dir string) (cgroupSample, error) {
+	if _, err := os.Stat(dir); err != nil {
+		return cgroupSample{}, err
+	}
+
+	var sample cgroupSample
+	cpuStat := readKeyValueFile(filepath.Join(dir, "cpu.stat"))
+	sample.Counters.CPUUsageUsec = cpuStat["usage_usec"]
+
+	sample.Counters.IOReadBytes, sample.Counters.IOWriteBytes = readIOStat(filepath.Join(dir, "io.stat"))
+
+	if value, err := readCgroupValue(filepath.Join(dir, "memory.current")); err == nil && value != nil {
+		sample.MemoryCurrent = *value
+	}
+	if value, err := readCgroupValue(filepath.Join(dir, "memory.max")); err == nil {
+		sample.MemoryMax = value
+	}
+	if value, err := readCgroupValue(filepath.Join(dir, "pids.current")); err == nil {
+		sample.Tasks = value
+	}
+
+	memoryEvents := readKeyValueFile(filepath.Join(dir, "memory.events"))
+	sample.OOM = memoryEvents["oom"]
+	sample.Counters.OOMKills = memoryEvents["oom_kill"]
+
+	pressure := &PressurePayload{
+		CPUSome:    readPressureAvg60(filepath.Join(dir, "cpu.pressure"), "some"),
+		MemorySome: readPressureAvg60(filepath.Join(dir, "memory.pressure"), "some"),
+		MemoryFull: readPressureAvg60(filepath.Join(dir, "memory.pressure"), "full"),
+		IOSome:     readPressureAvg60(filepath.Join(dir, "io.pressure"), "some"),
+		IOFull:     readPressureAvg60(filepath.Join(dir, "io.pressure"), "full"),
+	}
+	if pressure.CPUSome != nil || pressure.MemorySome != nil || pressure.IOSome != nil {
+		sample.Pressure = pressure
+	}
+	return sample, nil
+}
+
+// readCgroupValue reads a single-value interface file. Returns nil for the
+// literal "max" (no limit).
+func readCgroupValue(path string) (*int64, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	text := strings.TrimSpace(string(content))
+	if text == "max" {
+		return nil, nil
+	}
+	value, err := strconv.ParseInt(text, 10, 64)
+	if err != nil {
+		return nil, err
+	}
+	return &value, nil
+}
+
+// readKeyValueFile parses flat keyed files such as cpu.stat and
+// memory.events ("key value" per line).
+func readKeyValueFile(path string) map[string]uint64 {
+	values := map[string]uint64{}
+	f, err := os.Open(path)
+	if err != nil {
+		return values
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) != 2 {
+			continue
+		}
+		value, err := strconv.ParseUint(fields[1], 10, 64)
+		if err != nil {
+			continue
+		}
+		values[fields[0]] = value
+	}
+	return values
+}
+
+// readIOStat sums rbytes and wbytes over all devices in io.stat.
+func readIOStat(path string) (uint64, uint64) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, 0
+	}
+	defer f.Close()
+
+	var read, write uint64
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		for _, field := range strings.Fields(scanner.Text()) {
+			key, value, ok := strings.Cut(field, "=")
+			if !ok {
+				continue
+			}
+			n, err := strconv.ParseUint(value, 10, 64)
+			if err != nil {
+				continue
+			}
+			switch key {
+			case "rbytes":
+				read += n
+			case "wbytes":
+				write += n
+			}
+		}
+	}
+	return read, write
+}
+
+// readPressureAvg60 returns the avg60 share of the "some" or "full" line of a
+// PSI file, in percent.
+func readPressureAvg60(path string, kind string) *float64 {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil
+	}
+	for _, line := range strings.Split(string(content), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 || fields[0] != kind {
+			continue
+		}
+		for _, field := range fields[1:] {
+			if value, ok := strings.CutPrefix(field, "avg60="); ok {
+				avg, err := strconv.ParseFloat(value, 64)
+				if err != nil {
+					return nil
+				}
+				return &avg
+			}
+		}
+	}
+	return nil
+}
+
+// CollectServices reports resource usage of systemd services from their
+// cgroups under system.slice, including template instances such as
+// postgresql@15-main.service, which systemd places in a nested
+// system-<template>.slice. When allowlist is non-empty only those units are
+// reported; names may omit the ".service" suffix, and a template name such as
+// "postgresql@" selects all of its instances.
+func CollectServices(stateDir string, allowlist []string) ([]ServiceResourcesPayload, error) {
+	if !isCgroupV2() {
+		return nil, ErrCgroupV1
+	}
+
+	var dirs []string
+	for _, pattern := range []string{"*.service", filepath.Join("system-*.slice", "*.service")} {
+		matches, err := filepath.Glob(filepath.Join(cgroupRoot, "system.slice", pattern))
+		if err != nil {
+			return nil, err
+		}
+		dirs = append(dirs, matches...)
+	}
+
+	allowed := make(map[string]bool, len(allowlist))
+	for _, unit := range allowlist {
+		if !strings.HasSuffix(unit, ".service") {
+			unit += ".service"
+		}
+		allowed[unit] = true
+	}
+
+	groups := map[string]string{}
+	for _, dir := range dirs {
+		unit := filepath.Base(dir)
+		if len(allowed) > 0 && !allowed[unit] && !allowed[serviceTemplate(unit)] {
+			continue
+		}
+		groups[unit] = dir
+	}
+
+	samples, rates, err := sampleCgroups(filepath.Join(stateDir, "services_state.json"), groups)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]ServiceResourcesPayload, 0, len(samples))
+	for _, unit := range sortedKeys(samples) {
+		payload := ServiceResourcesPayload{Unit: unit}
+		applyCgroupSample(&payload.CgroupResourcesPayload, samples[unit], rates[unit])
+		result = append(result, payload)
+	}
+	return result, nil
+}
+
+// serviceTemplate returns the template unit of an instance such as
+// postgresql@15-main.service, i.e. postgresql@.service, or "" for units that
+// are not template instances.
+func serviceTemplate(unit string) string {
+	prefix, _, found := strings.Cut(unit, "@")
+	if !found {
+		return ""
+	}
+	return prefix + "@.service"
+}
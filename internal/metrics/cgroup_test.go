@@ -0,0 +1,82 @@
+package metrics
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestCollectServices(t *testing.T) {
+	root := t.TempDir()
+	oldRoot := cgroupRoot
+	cgroupRoot = root
+	t.Cleanup(func() { cgroupRoot = oldRoot })
+
+	if err := os.WriteFile(filepath.Join(root, "cgroup.controllers"), []byte("cpu memory io pids\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	services := map[string]string{
+		"system.slice/nginx.service":                                      "1000",
+		"system.slice/cron.service":                                       "2000",
+		"system.slice/system-postgresql.slice/postgresql@15-main.service": "3000",
+		"system.slice/system-postgresql.slice/postgresql@16-main.service": "4000",
+		"system.slice/system-getty.slice/getty@tty1.service":              "5000",
+	}
+	for dir, memory := range services {
+		path := filepath.Join(root, dir)
+		if err := os.MkdirAll(path, 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(path, "memory.current"), []byte(memory+"\n"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name      string
+		allowlist []string
+		want      string
+	}{
+		{
+			name: "all services",
+			want: "cron.service=2000,getty@tty1.service=5000,nginx.service=1000,postgresql@15-main.service=3000,postgresql@16-main.service=4000",
+		},
+		{
+			name:      "instance name",
+			allowlist: []string{"nginx", "postgresql@16-main"},
+			want:      "nginx.service=1000,postgresql@16-main.service=4000",
+		},
+		{
+			name:      "template name",
+			allowlist: []string{"postgresql@.service"},
+			want:      "postgresql@15-main.service=3000,postgresql@16-main.service=4000",
+		},
+		{
+			name:      "template name without suffix",
+			allowlist: []string{"getty@", "cron.service"},
+			want:      "cron.service=2000,getty@tty1.service=5000",
+		},
+		{
+			name:      "plain name does not select instances",
+			allowlist: []string{"postgresql"},
+			want:      "",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := CollectServices(t.TempDir(), tt.allowlist)
+			if err != nil {
+				t.Fatalf("CollectServices: %v", err)
+			}
+			var got []string
+			for _, service := range result {
+				got = append(got, service.Unit+"="+strconv.FormatInt(service.MemoryCurrentBytes, 10))
+			}
+			if strings.Join(got, ",") != tt.want {
+				t.Errorf("services = %s, want %s", strings.Join(got, ","), tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,40 @@
+package metrics
+
+import (
+	"sync"
+	"time"
+)
+
+const defaultCheckTimeout = 10 * time.Second
+
+// runChecks runs check for every item concurrently and returns the results
+// in the order of items.
+func runChecks[T any, R any](items []T, check func(T) R) []R {
+	if len(items) == 0 {
+		return nil
+	}
+	results := make([]R, len(items))
+	var wg sync.WaitGroup
+	for i, item := range items {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			results[i] = check(item)
+		}()
+	}
+	wg.Wait()
+	return results
+}
+
+// checkTimeout converts a configured timeout in seconds, defaulting to
+// defaultCheckTimeout when unset.
+func checkTimeout(seconds int) time.Duration {
+	if seconds <= 0 {
+		return defaultCheckTimeout
+	}
+	return time.Duration(seconds) * time.Second
+}
+
+func durationMs(d time.Duration) float64 {
+	return float64(d.Microseconds()) / 1000
+}
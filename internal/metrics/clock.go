@@ -0,0 +1,54 @@
+package metrics
+
+import "syscall"
+
+// Kernel time-sync constants from <linux/timex.h>.
+const (
+	timexStatusUnsync = 0x0040 // STA_UNSYNC
+	timexStatusNano   = 0x2000 // STA_NANO
+	timeError         = 5      // TIME_ERROR
+	// maxErrorLimitMicros mirrors NTP_PHASE_LIMIT: the kernel marks the
+	// clock unsynchronized once the maximum error reaches 16s.
+	maxErrorLimitMicros = 16_000_000
+)
+
+var clockStates = map[int]string{
+	0: "ok",
+	1: "insert_leap",
+	2: "delete_leap",
+	3: "leap_in_progress",
+	4: "leap_occurred",
+	5: "error",
+}
+
+// CollectClock reads the kernel time-sync state with a read-only adjtimex
+// call. Synchronized is false when no NTP daemon has disciplined the clock
+// recently, e.g. because chronyd or ntpd is not running.
+func CollectClock() (*ClockMetrics, error) {
+	var tx syscall.Timex
+	state, err := syscall.Adjtimex(&tx)
+	if err != nil {
+		return nil, err
+	}
+
+	status := int64(tx.Status)
+	offsetMicros := float64(tx.Offset)
+	if status&timexStatusNano != 0 {
+		offsetMicros /= 1000
+	}
+	maxError := int64(tx.Maxerror)
+
+	stateName, ok := clockStates[state]
+	if !ok {
+		stateName = "unknown"
+	}
+
+	return &ClockMetrics{
+		Synchronized:   state != timeError && status&timexStatusUnsync == 0 && maxError < maxErrorLimitMicros,
+		State:          stateName,
+		OffsetMicros:   offsetMicros,
+		FrequencyPPM:   float64(tx.Freq) / 65536,
+		EstErrorMicros: int64(tx.Esterror),
+		MaxErrorMicros: maxError,
+	}, nil
+}
@@ -0,0 +1,388 @@
+package metrics
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	cloudCacheTTL      = 6 * time.Hour
+	cloudRetryInterval = 30 * time.Minute
+	cloudFetchTimeout  = 5 * time.Second
+	azureAssetTag      = "7783-7084-3265-9085-8269-3286-77"
+)
+
+// DefaultCloudEndpoints are the link-local metadata service base URLs per
+// provider.
+var DefaultCloudEndpoints = map[string]string{
+	"aws":          "http://169.254.169.254",
+	"gcp":          "http://169.254.169.254",
+	"azure":        "http://169.254.169.254",
+	"hetzner":      "http://169.254.169.254",
+	"digitalocean": "http://169.254.169.254",
+}
+
+// CloudMetadataClient queries provider metadata services. Endpoints maps a
+// provider name to the base URL of its metadata service so tests can point
+// it at a local fake server.
+type CloudMetadataClient struct {
+	HTTPClient *http.Client
+	Endpoints  map[string]string
+}
+
+func NewCloudMetadataClient() *CloudMetadataClient {
+	endpoints := make(map[string]string, len(DefaultCloudEndpoints))
+	for provider, endpoint := range DefaultCloudEndpoints {
+		endpoints[provider] = endpoint
+	}
+	return &CloudMetadataClient{
+		// Metadata services answer in milliseconds; anything slower means
+		// the address is not routed and we should give up quickly.
+		HTTPClient: &http.Client{Timeout: 2 * time.Second},
+		Endpoints:  endpoints,
+	}
+}
+
+type cloudState struct {
+	Provider  string        `json:"provider"`
+	FetchedAt int64         `json:"fetchedAt"`
+	FailedAt  int64         `json:"failedAt,omitempty"`
+	Metadata  *CloudPayload `json:"metadata,omitempty"`
+}
+
+// CollectCloudMetadata detects the cloud provider from DMI data and returns
+// its instance metadata. Results are cached in the state directory so the
+// metadata service is only queried every few hours, and failed lookups are
+// not retried on every run. Returns nil when not running on a known cloud.
+func CollectCloudMetadata(stateDir string, client *CloudMetadataClient) *CloudPayload {
+	return collectCloudMetadata(stateDir, client, readDMI(), time.Now())
+}
+
+func collectCloudMetadata(stateDir string, client *CloudMetadataClient, dmi dmiInfo, now time.Time) *CloudPayload {
+	provider := DetectCloudProvider(dmi)
+	if provider == "" {
+		return nil
+	}
+
+	stateFile := filepath.Join(stateDir, "cloud_metadata.json")
+	var cached cloudState
+	if err := loadState(stateFile, &cached); err != nil || cached.Provider != provider {
+		cached = cloudState{Provider: provider}
+	}
+
+	if cached.Metadata != nil && now.Sub(time.Unix(cached.FetchedAt, 0)) < cloudCacheTTL {
+		return cached.Metadata
+	}
+	if cached.FailedAt != 0 && now.Sub(time.Unix(cached.FailedAt, 0)) < cloudRetryInterval {
+		return cached.Metadata
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), cloudFetchTimeout)
+	defer cancel()
+
+	metadata, err := client.Fetch(ctx, provider)
+	if err != nil {
+		log.Printf("WARN metrics cloud metadata fetch failed: provider=%s err=%v", provider, err)
+		cached.FailedAt = now.Unix()
+	} else {
+		cached = cloudState{Provider: provider, FetchedAt: now.Unix(), Metadata: metadata}
+	}
+	if err := saveState(stateFile, cached); err != nil {
+		log.Printf("WARN metrics cloud metadata cache save failed: %v", err)
+	}
+	// Stale metadata is better than none when the service is briefly down.
+	return cached.Metadata
+}
+
+// DetectCloudProvider identifies the cloud provider from DMI strings without
+// any network access. Returns "" when the host is not a known cloud instance.
+func DetectCloudProvider(dmi dmiInfo) string {
+	sysVendor := strings.ToLower(dmi.SysVendor)
+	switch {
+	case strings.Contains(sysVendor, "amazon ec2"),
+		strings.Contains(strings.ToLower(dmi.BIOSVendor), "amazon"),
+		strings.Contains(strings.ToLower(dmi.ProductVer), "amazon"):
+		return "aws"
+	case strings.Contains(strings.ToLower(dmi.ProductName), "google compute engine"),
+		strings.Contains(sysVendor, "google"):
+		return "gcp"
+	case dmi.ChassisAsset == azureAssetTag:
+		return "azure"
+	case strings.Contains(sysVendor, "hetzner"):
+		return "hetzner"
+	case strings.Contains(sysVendor, "digitalocean"):
+		return "digitalocean"
+	}
+	return ""
+}
+
+func (c *CloudMetadataClient) Fetch(ctx context.Context, provider string) (*CloudPayload, error) {
+	base, ok := c.Endpoints[provider]
+	if !ok {
+		return nil, fmt.Errorf("unsupported cloud provider %q", provider)
+	}
+	base = strings.TrimRight(base, "/")
+
+	switch provider {
+	case "aws":
+		return c.fetchAWS(ctx, base)
+	case "gcp":
+		return c.fetchGCP(ctx, base)
+	case "azure":
+		return c.fetchAzure(ctx, base)
+	case "hetzner":
+		return c.fetchHetzner(ctx, base)
+	case "digitalocean":
+		return c.fetchDigitalOcean(ctx, base)
+	}
+	return nil, fmt.Errorf("unsupported cloud provider %q", provider)
+}
+
+// fetchAWS uses the IMDSv2 session token flow and falls back to IMDSv1
+// requests when the token endpoint is unavailable.
+func (c *CloudMetadataClient) fetchAWS(ctx context.Context, base string) (*CloudPayload, error) {
+	token, err := c.request(ctx, http.MethodPut, base+"/latest/api/token", map[string]string{
+		"X-aws-ec2-metadata-token-ttl-seconds": "300",
+	})
+	if err != nil {
+		token = nil
+	}
+
+	headers := map[string]string{}
+	if len(token) > 0 {
+		headers["X-aws-ec2-metadata-token"] = strings.TrimSpace(string(token))
+	}
+	get := func(path string) (string, error) {
+		body, err := c.request(ctx, http.MethodGet, base+"/latest/meta-data/"+path, headers)
+		return strings.TrimSpace(string(body)), err
+	}
+
+	instanceID, err := get("instance-id")
+	if err != nil {
+		return nil, err
+	}
+	metadata := &CloudPayload{Provider: "aws", InstanceID: instanceID}
+	// The remaining fields are optional; public-ipv4 is a 404 for private
+	// instances.
+	metadata.InstanceType, _ = get("instance-type")
+	metadata.Region, _ = get("placement/region")
+	metadata.Zone, _ = get("placement/availability-zone")
+	metadata.Image, _ = get("ami-id")
+	metadata.PublicIP, _ = get("public-ipv4")
+	return metadata, nil
+}
+
+func (c *CloudMetadataClient) fetchGCP(ctx context.Context, base string) (*CloudPayload, error) {
+	body, err := c.request(ctx, http.MethodGet, base+"/computeMetadata/v1/instance/?recursive=true", map[string]string{
+		"Metadata-Flavor": "Google",
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var doc struct {
+		ID                json.Number `json:"id"`
+		MachineType       string      `json:"machineType"`
+		Zone              string      `json:"zone"`
+		Image             string      `json:"image"`
+		NetworkInterfaces []struct {
+			AccessConfigs []struct {
+				ExternalIP string `json:"externalIp"`
+			} `json:"accessConfigs"`
+		} `json:"networkInterfaces"`
+	}
+	if err := json.Unmarshal(body, &doc); err != nil {
+		return nil, err
+	}
+
+	// GCP returns resource paths such as projects/123/zones/europe-west1-b.
+	zone := lastPathSegment(doc.Zone)
+	metadata := &CloudPayload{
+		Provider:     "gcp",
+		InstanceID:   doc.ID.String(),
+		InstanceType: lastPathSegment(doc.MachineType),
+		Zone:         zone,
+		Image:        lastPathSegment(doc.Image),
+	}
+	if i := strings.LastIndex(zone, "-"); i > 0 {
+		metadata.Region = zone[:i]
+	}
+	for _, iface := range doc.NetworkInterfaces {
+		for _, access := range iface.AccessConfigs {
+			if access.ExternalIP != "" && metadata.PublicIP == "" {
+				metadata.PublicIP = access.ExternalIP
+			}
+		}
+	}
+	return metadata, nil
+}
+
+func (c *CloudMetadataClient) fetchAzure(ctx context.Context, base string) (*CloudPayload, error) {
+	body, err := c.request(ctx, http.MethodGet, base+"/metadata/instance?api-version=2021-02-01", map[string]string{
+		"Metadata": "true",
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var doc struct {
+		Compute struct {
+			VMID           string `json:"vmId"`
+			VMSize         string `json:"vmSize"`
+			Location       string `json:"location"`
+			Zone           string `json:"zone"`
+			StorageProfile struct {
+				ImageReference struct {
+					ID        string `json:"id"`
+					Publisher string `json:"publisher"`
+					Offer     string `json:"offer"`
+					SKU       string `json:"sku"`
+					Version   string `json:"version"`
+				} `json:"imageReference"`
+			} `json:"storageProfile"`
+		} `json:"compute"`
+		Network struct {
+			Interface []struct {
+				IPv4 struct {
+					IPAddress []struct {
+						PublicIPAddress string `json:"publicIpAddress"`
+					} `json:"ipAddress"`
+				} `json:"ipv4"`
+			} `json:"interface"`
+		} `json:"network"`
+	}
+	if err := json.Unmarshal(body, &doc); err != nil {
+		return nil, err
+	}
+
+	image := doc.Compute.StorageProfile.ImageReference
+	metadata := &CloudPayload{
+		Provider:     "azure",
+		InstanceID:   doc.Compute.VMID,
+		InstanceType: doc.Compute.VMSize,
+		Region:       doc.Compute.Location,
+		Zone:         doc.Compute.Zone,
+		Image:        image.ID,
+	}
+	if image.Publisher != "" {
+		metadata.Image = strings.Join([]string{image.Publisher, image.Offer, image.SKU, image.Version}, ":")
+	}
+	for _, iface := range doc.Network.Interface {
+		for _, addr := range iface.IPv4.IPAddress {
+			if addr.PublicIPAddress != "" && metadata.PublicIP == "" {
+				metadata.PublicIP = addr.PublicIPAddress
+			}
+		}
+	}
+	return metadata, nil
+}
+
+// fetchHetzner reads the Hetzner Cloud metadata document, which is YAML. Only
+// top-level scalar keys are needed, so it is parsed line by line.
+func (c *CloudMetadataClient) fetchHetzner(ctx context.Context, base string) (*CloudPayload, error) {
+	body, err := c.request(ctx, http.MethodGet, base+"/hetzner/v1/metadata", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	values := map[string]string{}
+	scanner := bufio.NewScanner(strings.NewReader(string(body)))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" || line[0] == ' ' || line[0] == '-' || line[0] == '#' {
+			continue
+		}
+		key, value, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		values[strings.TrimSpace(key)] = strings.Trim(strings.TrimSpace(value), `"'`)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if values["instance-id"] == "" {
+		return nil, errors.New("hetzner metadata is missing instance-id")
+	}
+
+	return &CloudPayload{
+		Provider:   "hetzner",
+		InstanceID: values["instance-id"],
+		Region:     values["region"],
+		Zone:       values["availability-zone"],
+		PublicIP:   values["public-ipv4"],
+	}, nil
+}
+
+func (c *CloudMetadataClient) fetchDigitalOcean(ctx context.Context, base string) (*CloudPayload, error) {
+	body, err := c.request(ctx, http.MethodGet, base+"/metadata/v1.json", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var doc struct {
+		DropletID  json.Number `json:"droplet_id"`
+		Region     string      `json:"region"`
+		Interfaces struct {
+			Public []struct {
+				IPv4 struct {
+					IPAddress string `json:"ip_address"`
+				} `json:"ipv4"`
+			} `json:"public"`
+		} `json:"interfaces"`
+	}
+	if err := json.Unmarshal(body, &doc); err != nil {
+		return nil, err
+	}
+
+	metadata := &CloudPayload{
+		Provider:   "digitalocean",
+		InstanceID: doc.DropletID.String(),
+		Region:     doc.Region,
+	}
+	if len(doc.Interfaces.Public) > 0 {
+		metadata.PublicIP = doc.Interfaces.Public[0].IPv4.IPAddress
+	}
+	return metadata, nil
+}
+
+func (c *CloudMetadataClient) request(ctx context.Context, method string, url string, headers map[string]string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, method, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	for key, value := range headers {
+		req.Header.Set(key, value)
+	}
+
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return nil, fmt.Errorf("%s %s: http %d", method, url, resp.StatusCode)
+	}
+	return body, nil
+}
+
+func lastPathSegment(value string) string {
+	if i := strings.LastIndex(value, "/"); i >= 0 {
+		return value[i+1:]
+	}
+	return value
+}
@@ -0,0 +1,303 @@
+package metrics
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// fakeAWSMetadata serves IMDS. With requireToken set it behaves like an
+// instance enforcing IMDSv2; with tokenStatus set the token endpoint fails.
+type fakeAWSMetadata struct {
+	requireToken bool
+	tokenStatus  int
+	tokenCalls   atomic.Int32
+	metadataHits atomic.Int32
+}
+
+func (f *fakeAWSMetadata) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path == "/latest/api/token" {
+		f.tokenCalls.Add(1)
+		if r.Method != http.MethodPut || r.Header.Get("X-aws-ec2-metadata-token-ttl-seconds") == "" {
+			http.Error(w, "bad token request", http.StatusBadRequest)
+			return
+		}
+		if f.tokenStatus != 0 {
+			w.WriteHeader(f.tokenStatus)
+			return
+		}
+		w.Write([]byte("secret-token\n"))
+		return
+	}
+
+	f.metadataHits.Add(1)
+	token := r.Header.Get("X-aws-ec2-metadata-token")
+	if r.Method != http.MethodGet || (f.requireToken && token != "secret-token") {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	values := map[string]string{
+		"/latest/meta-data/instance-id":                 "i-0abc123",
+		"/latest/meta-data/instance-type":               "t3.small",
+		"/latest/meta-data/placement/region":            "eu-central-1",
+		"/latest/meta-data/placement/availability-zone": "eu-central-1a",
+		"/latest/meta-data/ami-id":                      "ami-0def456",
+	}
+	value, ok := values[r.URL.Path]
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	w.Write([]byte(value))
+}
+
+func testCloudClient(provider string, server *httptest.Server) *CloudMetadataClient {
+	return &CloudMetadataClient{
+		HTTPClient: server.Client(),
+		Endpoints:  map[string]string{provider: server.URL + "/"},
+	}
+}
+
+func TestFetchAWS(t *testing.T) {
+	want := &CloudPayload{
+		Provider:     "aws",
+		InstanceID:   "i-0abc123",
+		InstanceType: "t3.small",
+		Region:       "eu-central-1",
+		Zone:         "eu-central-1a",
+		Image:        "ami-0def456",
+	}
+
+	tests := []struct {
+		name string
+		fake *fakeAWSMetadata
+	}{
+		{name: "IMDSv2 token", fake: &fakeAWSMetadata{requireToken: true}},
+		{name: "IMDSv1 fallback when token request fails", fake: &fakeAWSMetadata{tokenStatus: http.StatusForbidden}},
+		{name: "IMDSv1 fallback when token endpoint is missing", fake: &fakeAWSMetadata{tokenStatus: http.StatusNotFound}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewServer(tt.fake)
+			defer server.Close()
+
+			got, err := testCloudClient("aws", server).Fetch(context.Background(), "aws")
+			if err != nil {
+				t.Fatalf("Fetch: %v", err)
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("Fetch = %+v, want %+v", got, want)
+			}
+			if tt.fake.tokenCalls.Load() != 1 {
+				t.Errorf("token requested %d times, want once", tt.fake.tokenCalls.Load())
+			}
+		})
+	}
+}
+
+func TestFetchAWSRequiresInstanceID(t *testing.T) {
+	server := httptest.NewServer(&fakeAWSMetadata{requireToken: true, tokenStatus: http.StatusForbidden})
+	defer server.Close()
+
+	if got, err := testCloudClient("aws", server).Fetch(context.Background(), "aws"); err == nil {
+		t.Errorf("Fetch = %+v, want an error when the metadata service refuses IMDSv1", got)
+	}
+}
+
+func TestFetchProviders(t *testing.T) {
+	tests := []struct {
+		provider string
+		path     string
+		header   [2]string
+		body     string
+		want     *CloudPayload
+	}{
+		{
+			provider: "gcp",
+			path:     "/computeMetadata/v1/instance/",
+			header:   [2]string{"Metadata-Flavor", "Google"},
+			body: `{"id": 4520031799277581759, "machineType": "projects/123/machineTypes/e2-small",
+				"zone": "projects/123/zones/europe-west1-b", "image": "projects/debian-cloud/global/images/debian-12",
+				"networkInterfaces": [{"accessConfigs": [{"externalIp": ""}, {"externalIp": "34.1.2.3"}]}]}`,
+			want: &CloudPayload{
+				Provider:     "gcp",
+				InstanceID:   "4520031799277581759",
+				InstanceType: "e2-small",
+				Region:       "europe-west1",
+				Zone:         "europe-west1-b",
+				Image:        "debian-12",
+				PublicIP:     "34.1.2.3",
+			},
+		},
+		{
+			provider: "azure",
+			path:     "/metadata/instance",
+			header:   [2]string{"Metadata", "true"},
+			body: `{"compute": {"vmId": "5c08b38e-4d57-4c23-ac45-aca61037f084", "vmSize": "Standard_B1s",
+				"location": "westeurope", "zone": "2",
+				"storageProfile": {"imageReference": {"publisher": "Canonical", "offer": "ubuntu-24_04-lts", "sku": "server", "version": "latest"}}},
+				"network": {"interface": [{"ipv4": {"ipAddress": [{"publicIpAddress": "20.1.2.3"}]}}]}}`,
+			want: &CloudPayload{
+				Provider:     "azure",
+				InstanceID:   "5c08b38e-4d57-4c23-ac45-aca61037f084",
+				InstanceType: "Standard_B1s",
+				Region:       "westeurope",
+				Zone:         "2",
+				Image:        "Canonical:ubuntu-24_04-lts:server:latest",
+				PublicIP:     "20.1.2.3",
+			},
+		},
+		{
+			provider: "hetzner",
+			path:     "/hetzner/v1/metadata",
+			body: "availability-zone: fsn1-dc14\nhostname: web-1\ninstance-id: 12345678\n" +
+				"public-ipv4: 49.12.1.2\nregion: eu-central\npublic-keys:\n- ssh-ed25519 AAAA\n",
+			want: &CloudPayload{
+				Provider:   "hetzner",
+				InstanceID: "12345678",
+				Region:     "eu-central",
+				Zone:       "fsn1-dc14",
+				PublicIP:   "49.12.1.2",
+			},
+		},
+		{
+			provider: "digitalocean",
+			path:     "/metadata/v1.json",
+			body:     `{"droplet_id": 2756294, "region": "ams3", "interfaces": {"public": [{"ipv4": {"ip_address": "104.131.1.2"}}]}}`,
+			want: &CloudPayload{
+				Provider:   "digitalocean",
+				InstanceID: "2756294",
+				Region:     "ams3",
+				PublicIP:   "104.131.1.2",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.provider, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path != tt.path {
+					http.NotFound(w, r)
+					return
+				}
+				if tt.header[0] != "" && r.Header.Get(tt.header[0]) != tt.header[1] {
+					http.Error(w, "missing metadata header", http.StatusForbidden)
+					return
+				}
+				w.Write([]byte(tt.body))
+			}))
+			defer server.Close()
+
+			got, err := testCloudClient(tt.provider, server).Fetch(context.Background(), tt.provider)
+			if err != nil {
+				t.Fatalf("Fetch: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Fetch = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFetchTimeout(t *testing.T) {
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer server.Close()
+	defer close(release)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if got, err := testCloudClient("gcp", server).Fetch(ctx, "gcp"); err == nil {
+		t.Errorf("Fetch = %+v, want a timeout error", got)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("Fetch took %s, want it to give up at the deadline", elapsed)
+	}
+}
+
+func TestDetectCloudProvider(t *testing.T) {
+	tests := []struct {
+		name string
+		dmi  dmiInfo
+		want string
+	}{
+		{name: "no cloud", dmi: dmiInfo{SysVendor: "Dell Inc.", ProductName: "PowerEdge R640"}, want: ""},
+		{name: "no DMI", dmi: dmiInfo{}, want: ""},
+		{name: "aws nitro", dmi: dmiInfo{SysVendor: "Amazon EC2"}, want: "aws"},
+		{name: "aws xen", dmi: dmiInfo{SysVendor: "Xen", ProductVer: "4.11.amazon"}, want: "aws"},
+		{name: "gcp", dmi: dmiInfo{SysVendor: "Google", ProductName: "Google Compute Engine"}, want: "gcp"},
+		{name: "azure", dmi: dmiInfo{SysVendor: "Microsoft Corporation", ChassisAsset: azureAssetTag}, want: "azure"},
+		{name: "hyper-v outside azure", dmi: dmiInfo{SysVendor: "Microsoft Corporation"}, want: ""},
+		{name: "hetzner", dmi: dmiInfo{SysVendor: "Hetzner"}, want: "hetzner"},
+		{name: "digitalocean", dmi: dmiInfo{SysVendor: "DigitalOcean"}, want: "digitalocean"},
+	}
+	for _, tt := range tests {
+		if got := DetectCloudProvider(tt.dmi); got != tt.want {
+			t.Errorf("%s: DetectCloudProvider = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestCollectCloudMetadataNoCloud(t *testing.T) {
+	stateDir := t.TempDir()
+	client := &CloudMetadataClient{HTTPClient: http.DefaultClient, Endpoints: map[string]string{}}
+
+	if got := collectCloudMetadata(stateDir, client, dmiInfo{SysVendor: "QEMU"}, time.Now()); got != nil {
+		t.Errorf("collectCloudMetadata = %+v, want nil", got)
+	}
+	if _, err := os.Stat(filepath.Join(stateDir, "cloud_metadata.json")); !os.IsNotExist(err) {
+		t.Errorf("cache written without a cloud provider: %v", err)
+	}
+}
+
+func TestCollectCloudMetadataCaching(t *testing.T) {
+	fake := &fakeAWSMetadata{requireToken: true}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	stateDir := t.TempDir()
+	client := testCloudClient("aws", server)
+	dmi := dmiInfo{SysVendor: "Amazon EC2"}
+	now := time.Now()
+
+	first := collectCloudMetadata(stateDir, client, dmi, now)
+	if first == nil || first.InstanceID != "i-0abc123" {
+		t.Fatalf("first collection = %+v", first)
+	}
+	hits := fake.metadataHits.Load()
+
+	cached := collectCloudMetadata(stateDir, client, dmi, now.Add(time.Hour))
+	if !reflect.DeepEqual(cached, first) || fake.metadataHits.Load() != hits {
+		t.Errorf("cached collection = %+v after %d requests, want the cached metadata without requests", cached, fake.metadataHits.Load()-hits)
+	}
+
+	// Once the cache expired, a failing service keeps the stale metadata and
+	// is not asked again within the retry interval.
+	server.Close()
+	expired := now.Add(cloudCacheTTL + time.Minute)
+	if got := collectCloudMetadata(stateDir, client, dmi, expired); !reflect.DeepEqual(got, first) {
+		t.Errorf("collection with failing service = %+v, want stale metadata", got)
+	}
+	var state cloudState
+	if err := loadState(filepath.Join(stateDir, "cloud_metadata.json"), &state); err != nil {
+		t.Fatalf("load cache: %v", err)
+	}
+	if state.FailedAt != expired.Unix() || state.Metadata == nil {
+		t.Errorf("cache after failure = %+v, want failedAt %d and the old metadata", state, expired.Unix())
+	}
+	if got := collectCloudMetadata(stateDir, client, dmi, expired.Add(time.Minute)); !reflect.DeepEqual(got, first) {
+		t.Errorf("collection within retry interval = %+v, want stale metadata", got)
+	}
+	if err := loadState(filepath.Join(stateDir, "cloud_metadata.json"), &state); err != nil || state.FailedAt != expired.Unix() {
+		t.Errorf("cache after retry interval check = %+v, %v, want failedAt unchanged", state, err)
+	}
+}
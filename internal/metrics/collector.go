@@ -1,11 +1,102 @@
 package metrics
 
 import (
+	"errors"
 	"log"
+	"os"
 	"time"
 )
 
-func Collect() (*Payload, error) {
+func Collect(stateDir string) (*Payload, error) {
+	payload := CollectBase()
+
+	network, err := CollectNetwork(stateDir)
+	if err != nil {
+		log.Printf("WARN metrics network collection failed: %v", err)
+	} else if network != nil {
+		payload.Network = &NetworkPayload{
+			RxBytesPerSec: network.RxBytesPerSec,
+			TxBytesPerSec: network.TxBytesPerSec,
+		}
+		if network.Softnet != nil {
+			softnet := &SoftnetPayload{
+				ProcessedPerSec:   network.Softnet.ProcessedPerSec,
+				DroppedPerSec:     network.Softnet.DroppedPerSec,
+				TimeSqueezePerSec: network.Softnet.TimeSqueezePerSec,
+			}
+			for _, cpu := range network.Softnet.CPUs {
+				softnet.CPUs = append(softnet.CPUs, SoftnetCPUPayload{
+					CPU:               cpu.CPU,
+					ProcessedPerSec:   cpu.ProcessedPerSec,
+					DroppedPerSec:     cpu.DroppedPerSec,
+					TimeSqueezePerSec: cpu.TimeSqueezePerSec,
+				})
+			}
+			payload.Network.Softnet = softnet
+		}
+		for _, iface := range network.Interfaces {
+			payload.Network.Interfaces = append(payload.Network.Interfaces, InterfaceDropsPayload{
+				Name:               iface.Name,
+				RxMissedPerSec:     iface.RxMissedPerSec,
+				RxFifoErrorsPerSec: iface.RxFifoErrorsPerSec,
+				TxFifoErrorsPerSec: iface.TxFifoErrorsPerSec,
+			})
+		}
+	}
+
+	clock, err := CollectClock()
+	if err != nil {
+		log.Printf("WARN metrics clock collection failed: %v", err)
+	} else {
+		payload.Clock = &ClockPayload{
+			Synchronized:   clock.Synchronized,
+			State:          clock.State,
+			OffsetMicros:   clock.OffsetMicros,
+			FrequencyPPM:   clock.FrequencyPPM,
+			EstErrorMicros: clock.EstErrorMicros,
+			MaxErrorMicros: clock.MaxErrorMicros,
+		}
+	}
+
+	reboot, err := CollectRebootRequired()
+	if err != nil {
+		log.Printf("WARN metrics reboot-required collection failed: %v", err)
+	} else {
+		payload.Reboot = &RebootPayload{
+			Required:          reboot.Required,
+			Reasons:           reboot.Reasons,
+			Packages:          reboot.Packages,
+			RunningKernel:     reboot.RunningKernel,
+			LatestKernel:      reboot.LatestKernel,
+			DeletedLibraries:  reboot.DeletedLibraries,
+			AffectedProcesses: reboot.AffectedProcesses,
+		}
+	}
+
+	containers, err := CollectContainers(stateDir)
+	if err != nil {
+		if !errors.Is(err, ErrCgroupV1) {
+			log.Printf("WARN metrics container collection failed: %v", err)
+		}
+	} else {
+		payload.Containers = containers
+	}
+
+	sessions, err := CollectSessions()
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Printf("WARN metrics sessions collection failed: %v", err)
+		}
+	} else {
+		payload.Sessions = sessions
+	}
+
+	return payload, nil
+}
+
+// CollectBase returns a payload with the host's CPU, memory, disk and uptime,
+// the collectors that keep no state between runs.
+func CollectBase() *Payload {
 	payload := &Payload{
 		Hostname: GetHostname(),
 		TS:       time.Now().Unix(),
@@ -44,16 +135,6 @@ func Collect() (*Payload, error) {
 		}
 	}
 
-	network, err := CollectNetwork("/var/lib/mightymonitor")
-	if err != nil {
-		log.Printf("WARN metrics network collection failed: %v", err)
-	} else if network != nil {
-		payload.Network = &NetworkPayload{
-			RxBytesPerSec: network.RxBytesPerSec,
-			TxBytesPerSec: network.TxBytesPerSec,
-		}
-	}
-
 	uptime, err := GetUptime()
 	if err != nil {
 		log.Printf("WARN metrics uptime collection failed: %v", err)
@@ -61,5 +142,5 @@ func Collect() (*Payload, error) {
 		payload.UptimeSeconds = uptime
 	}
 
-	return payload, nil
+	return payload
 }
@@ -0,0 +1,514 @@
+package metrics
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// This file implements the small part of the D-Bus wire protocol the agent
+// needs: EXTERNAL authentication and method calls with string arguments.
+// Replies are decoded generically into Go values.
+
+const defaultSystemBusAddress = "unix:path=/run/dbus/system_bus_socket"
+
+const (
+	dbusMethodCall   = 1
+	dbusMethodReturn = 2
+	dbusError        = 3
+
+	dbusFieldPath        = 1
+	dbusFieldInterface   = 2
+	dbusFieldMember      = 3
+	dbusFieldErrorName   = 4
+	dbusFieldReplySerial = 5
+	dbusFieldDestination = 6
+	dbusFieldSignature   = 8
+
+	maxDBusMessageSize = 128 << 20
+)
+
+// ErrNoSystemBus is returned when the system bus socket does not exist, e.g.
+// in containers or on hosts without systemd.
+var ErrNoSystemBus = errors.New("D-Bus system bus not available")
+
+type dbusConn struct {
+	conn   net.Conn
+	reader *bufio.Reader
+	serial uint32
+}
+
+// dialSystemBus connects and authenticates to the system bus.
+func dialSystemBus(ctx context.Context) (*dbusConn, error) {
+	path, err := systemBusSocketPath()
+	if err != nil {
+		return nil, err
+	}
+	if _, err := os.Stat(path); err != nil {
+		return nil, ErrNoSystemBus
+	}
+
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "unix", path)
+	if err != nil {
+		return nil, err
+	}
+	c := &dbusConn{conn: conn, reader: bufio.NewReader(conn)}
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+	if err := c.authenticate(); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("dbus auth: %w", err)
+	}
+	if _, err := c.call("org.freedesktop.DBus", "/org/freedesktop/DBus", "org.freedesktop.DBus", "Hello"); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("dbus hello: %w", err)
+	}
+	return c, nil
+}
+
+func systemBusSocketPath() (string, error) {
+	address := os.Getenv("DBUS_SYSTEM_BUS_ADDRESS")
+	if address == "" {
+		address = defaultSystemBusAddress
+	}
+	// Only the first address of a ';' separated list is used.
+	address, _, _ = strings.Cut(address, ";")
+	transport, params, ok := strings.Cut(address, ":")
+	if !ok || transport != "unix" {
+		return "", fmt.Errorf("unsupported D-Bus address %q", address)
+	}
+	for _, param := range strings.Split(params, ",") {
+		if value, ok := strings.CutPrefix(param, "path="); ok {
+			return value, nil
+		}
+	}
+	return "", fmt.Errorf("unsupported D-Bus address %q", address)
+}
+
+func (c *dbusConn) authenticate() error {
+	uid := strconv.Itoa(os.Getuid())
+	if _, err := c.conn.Write([]byte("\x00AUTH EXTERNAL " + hex.EncodeToString([]byte(uid)) + "\r\n")); err != nil {
+		return err
+	}
+	line, err := c.reader.ReadString('\n')
+	if err != nil {
+		return err
+	}
+	if !strings.HasPrefix(line, "OK ") {
+		return fmt.Errorf("server rejected EXTERNAL authentication: %s", strings.TrimSpace(line))
+	}
+	_, err = c.conn.Write([]byte("BEGIN\r\n"))
+	return err
+}
+
+func (c *dbusConn) Close() error {
+	return c.conn.Close()
+}
+
+// call invokes a method whose arguments are all strings and returns the
+// decoded reply body.
+func (c *dbusConn) call(destination string, path string, iface string, member string, args ...string) ([]any, error) {
+	c.serial++
+	serial := c.serial
+
+	var body dbusEncoder
+	for _, arg := range args {
+		body.writeString(arg)
+	}
+
+	var msg dbusEncoder
+	msg.buf.WriteByte('l')
+	msg.buf.WriteByte(dbusMethodCall)
+	msg.buf.WriteByte(0)
+	msg.buf.WriteByte(1)
+	msg.writeUint32(uint32(body.buf.Len()))
+	msg.writeUint32(serial)
+
+	type field struct {
+		code      byte
+		signature string
+		value     string
+	}
+	fields := []field{
+		{dbusFieldPath, "o", path},
+		{dbusFieldInterface, "s", iface},
+		{dbusFieldMember, "s", member},
+		{dbusFieldDestination, "s", destination},
+	}
+	if len(args) > 0 {
+		fields = append(fields, field{dbusFieldSignature, "g", strings.Repeat("s", len(args))})
+	}
+
+	lengthOffset := msg.buf.Len()
+	msg.writeUint32(0)
+	msg.align(8)
+	start := msg.buf.Len()
+	for _, f := range fields {
+		msg.align(8)
+		msg.buf.WriteByte(f.code)
+		msg.writeSignature(f.signature)
+		if f.signature == "g" {
+			msg.writeSignature(f.value)
+		} else {
+			msg.writeString(f.value)
+		}
+	}
+	binary.LittleEndian.PutUint32(msg.buf.Bytes()[lengthOffset:], uint32(msg.buf.Len()-start))
+	msg.align(8)
+	msg.buf.Write(body.buf.Bytes())
+
+	if _, err := c.conn.Write(msg.buf.Bytes()); err != nil {
+		return nil, err
+	}
+
+	for {
+		reply, err := c.readMessage()
+		if err != nil {
+			return nil, err
+		}
+		if reply.replySerial != serial {
+			// Signals such as NameAcquired and replies we no longer wait for.
+			continue
+		}
+		if reply.msgType == dbusError {
+			message := ""
+			if len(reply.body) > 0 {
+				message, _ = reply.body[0].(string)
+			}
+			return nil, fmt.Errorf("%s: %s", reply.errorName, message)
+		}
+		return reply.body, nil
+	}
+}
+
+type dbusMessage struct {
+	msgType     byte
+	replySerial uint32
+	errorName   string
+	body        []any
+}
+
+func (c *dbusConn) readMessage() (*dbusMessage, error) {
+	fixed := make([]byte, 16)
+	if _, err := io.ReadFull(c.reader, fixed); err != nil {
+		return nil, err
+	}
+
+	var order binary.ByteOrder
+	switch fixed[0] {
+	case 'l':
+		order = binary.LittleEndian
+	case 'B':
+		order = binary.BigEndian
+	default:
+		return nil, fmt.Errorf("invalid endianness marker %q", fixed[0])
+	}
+	bodyLen := order.Uint32(fixed[4:8])
+	fieldsLen := order.Uint32(fixed[12:16])
+	headerLen := 16 + int(fieldsLen)
+	padding := (8 - headerLen%8) % 8
+	total := headerLen + padding + int(bodyLen)
+	if total > maxDBusMessageSize {
+		return nil, fmt.Errorf("dbus message too large (%d bytes)", total)
+	}
+
+	data := make([]byte, total)
+	copy(data, fixed)
+	if _, err := io.ReadFull(c.reader, data[16:]); err != nil {
+		return nil, err
+	}
+
+	msg := &dbusMessage{msgType: fixed[1]}
+	header := &dbusDecoder{data: data[:headerLen], order: order, pos: 12}
+	rawFields, err := header.decode("a(yv)")
+	if err != nil {
+		return nil, fmt.Errorf("decode header: %w", err)
+	}
+	signature := ""
+	for _, raw := range rawFields.([]any) {
+		f := raw.([]any)
+		switch f[0].(byte) {
+		case dbusFieldReplySerial:
+			msg.replySerial, _ = f[1].(uint32)
+		case dbusFieldErrorName:
+			msg.errorName, _ = f[1].(string)
+		case dbusFieldSignature:
+			signature, _ = f[1].(string)
+		}
+	}
+
+	// Body offsets are relative to the message start, which is 8-aligned.
+	body := &dbusDecoder{data: data, order: order, pos: headerLen + padding}
+	for signature != "" {
+		single, rest, err := splitDBusSignature(signature)
+		if err != nil {
+			return nil, err
+		}
+		value, err := body.decode(single)
+		if err != nil {
+			return nil, fmt.Errorf("decode body: %w", err)
+		}
+		msg.body = append(msg.body, value)
+		signature = rest
+	}
+	return msg, nil
+}
+
+type dbusEncoder struct {
+	buf bytes.Buffer
+}
+
+func (e *dbusEncoder) align(n int) {
+	for e.buf.Len()%n != 0 {
+		e.buf.WriteByte(0)
+	}
+}
+
+func (e *dbusEncoder) writeUint32(v uint32) {
+	e.align(4)
+	var b [4]byte
+	binary.LittleEndian.PutUint32(b[:], v)
+	e.buf.Write(b[:])
+}
+
+func (e *dbusEncoder) writeString(s string) {
+	e.writeUint32(uint32(len(s)))
+	e.buf.WriteString(s)
+	e.buf.WriteByte(0)
+}
+
+func (e *dbusEncoder) writeSignature(s string) {
+	e.buf.WriteByte(byte(len(s)))
+	e.buf.WriteString(s)
+	e.buf.WriteByte(0)
+}
+
+type dbusDecoder struct {
+	data  []byte
+	order binary.ByteOrder
+	pos   int
+}
+
+var errDBusShort = errors.New("dbus message truncated")
+
+func (d *dbusDecoder) align(n int) error {
+	pos := (d.pos + n - 1) / n * n
+	if pos > len(d.data) {
+		return errDBusShort
+	}
+	d.pos = pos
+	return nil
+}
+
+func (d *dbusDecoder) take(n int) ([]byte, error) {
+	if n < 0 || d.pos+n > len(d.data) {
+		return nil, errDBusShort
+	}
+	b := d.data[d.pos : d.pos+n]
+	d.pos += n
+	return b, nil
+}
+
+func (d *dbusDecoder) fixed(size int) ([]byte, error) {
+	if err := d.align(size); err != nil {
+		return nil, err
+	}
+	return d.take(size)
+}
+
+// decode reads one value of the single complete type signature.
+func (d *dbusDecoder) decode(signature string) (any, error) {
+	switch signature[0] {
+	case 'y':
+		b, err := d.take(1)
+		if err != nil {
+			return nil, err
+		}
+		return b[0], nil
+	case 'b':
+		b, err := d.fixed(4)
+		if err != nil {
+			return nil, err
+		}
+		return d.order.Uint32(b) != 0, nil
+	case 'n':
+		b, err := d.fixed(2)
+		if err != nil {
+			return nil, err
+		}
+		return int16(d.order.Uint16(b)), nil
+	case 'q':
+		b, err := d.fixed(2)
+		if err != nil {
+			return nil, err
+		}
+		return d.order.Uint16(b), nil
+	case 'i':
+		b, err := d.fixed(4)
+		if err != nil {
+			return nil, err
+		}
+		return int32(d.order.Uint32(b)), nil
+	case 'u', 'h':
+		b, err := d.fixed(4)
+		if err != nil {
+			return nil, err
+		}
+		return d.order.Uint32(b), nil
+	case 'x':
+		b, err := d.fixed(8)
+		if err != nil {
+			return nil, err
+		}
+		return int64(d.order.Uint64(b)), nil
+	case 't':
+		b, err := d.fixed(8)
+		if err != nil {
+			return nil, err
+		}
+		return d.order.Uint64(b), nil
+	case 'd':
+		b, err := d.fixed(8)
+		if err != nil {
+			return nil, err
+		}
+		return math.Float64frombits(d.order.Uint64(b)), nil
+	case 's', 'o':
+		b, err := d.fixed(4)
+		if err != nil {
+			return nil, err
+		}
+		s, err := d.take(int(d.order.Uint32(b)) + 1)
+		if err != nil {
+			return nil, err
+		}
+		return string(s[:len(s)-1]), nil
+	case 'g':
+		return d.signature()
+	case 'v':
+		inner, err := d.signature()
+		if err != nil {
+			return nil, err
+		}
+		if inner == "" {
+			return nil, errors.New("empty variant signature")
+		}
+		return d.decode(inner)
+	case 'a':
+		b, err := d.fixed(4)
+		if err != nil {
+			return nil, err
+		}
+		length := int(d.order.Uint32(b))
+		elem := signature[1:]
+		if err := d.align(dbusAlignment(elem[0])); err != nil {
+			return nil, err
+		}
+		end := d.pos + length
+		if end > len(d.data) {
+			return nil, errDBusShort
+		}
+		items := []any{}
+		for d.pos < end {
+			item, err := d.decode(elem)
+			if err != nil {
+				return nil, err
+			}
+			items = append(items, item)
+		}
+		return items, nil
+	case '(', '{':
+		if err := d.align(8); err != nil {
+			return nil, err
+		}
+		inner := signature[1 : len(signature)-1]
+		var fields []any
+		for inner != "" {
+			single, rest, err := splitDBusSignature(inner)
+			if err != nil {
+				return nil, err
+			}
+			value, err := d.decode(single)
+			if err != nil {
+				return nil, err
+			}
+			fields = append(fields, value)
+			inner = rest
+		}
+		return fields, nil
+	}
+	return nil, fmt.Errorf("unsupported dbus type %q", signature[0])
+}
+
+func (d *dbusDecoder) signature() (string, error) {
+	b, err := d.take(1)
+	if err != nil {
+		return "", err
+	}
+	s, err := d.take(int(b[0]) + 1)
+	if err != nil {
+		return "", err
+	}
+	return string(s[:len(s)-1]), nil
+}
+
+func dbusAlignment(code byte) int {
+	switch code {
+	case 'y', 'g', 'v':
+		return 1
+	case 'n', 'q':
+		return 2
+	case 'x', 't', 'd', '(', '{':
+		return 8
+	}
+	return 4
+}
+
+// splitDBusSignature splits the first single complete type off signature.
+func splitDBusSignature(signature string) (string, string, error) {
+	if signature == "" {
+		return "", "", errors.New("empty signature")
+	}
+	switch signature[0] {
+	case 'a':
+		elem, rest, err := splitDBusSignature(signature[1:])
+		if err != nil {
+			return "", "", err
+		}
+		return "a" + elem, rest, nil
+	case '(', '{':
+		closing := byte(')')
+		if signature[0] == '{' {
+			closing = '}'
+		}
+		depth := 0
+		for i := 0; i < len(signature); i++ {
+			switch signature[i] {
+			case '(', '{':
+				depth++
+			case ')', '}':
+				depth--
+				if depth == 0 {
+					if signature[i] != closing {
+						return "", "", fmt.Errorf("malformed signature %q", signature)
+					}
+					return signature[:i+1], signature[i+1:], nil
+				}
+			}
+		}
+		return "", "", fmt.Errorf("malformed signature %q", signature)
+	}
+	return signature[:1], signature[1:], nil
+}
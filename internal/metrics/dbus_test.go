@@ -0,0 +1,455 @@
+package metrics
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// dbusWire builds D-Bus wire data for tests. Offsets are relative to the
+// start of buf, which stands for an 8-aligned position in a message.
+type dbusWire struct {
+	order binary.AppendByteOrder
+	buf   []byte
+}
+
+func (w *dbusWire) pad(n int) {
+	for len(w.buf)%n != 0 {
+		w.buf = append(w.buf, 0)
+	}
+}
+
+func (w *dbusWire) byte(b byte) {
+	w.buf = append(w.buf, b)
+}
+
+func (w *dbusWire) uint32(v uint32) {
+	w.pad(4)
+	w.buf = w.order.AppendUint32(w.buf, v)
+}
+
+func (w *dbusWire) uint64(v uint64) {
+	w.pad(8)
+	w.buf = w.order.AppendUint64(w.buf, v)
+}
+
+func (w *dbusWire) string(s string) {
+	w.uint32(uint32(len(s)))
+	w.buf = append(w.buf, s...)
+	w.buf = append(w.buf, 0)
+}
+
+func (w *dbusWire) signature(s string) {
+	w.buf = append(w.buf, byte(len(s)))
+	w.buf = append(w.buf, s...)
+	w.buf = append(w.buf, 0)
+}
+
+// array writes an array whose elements have the given alignment; the length
+// excludes the padding before the first element.
+func (w *dbusWire) array(alignment int, elements func()) {
+	w.uint32(0)
+	lengthAt := len(w.buf) - 4
+	w.pad(alignment)
+	start := len(w.buf)
+	elements()
+	length := uint32(len(w.buf) - start)
+	copy(w.buf[lengthAt:], w.order.AppendUint32(nil, length))
+}
+
+func TestDBusDecode(t *testing.T) {
+	tests := []struct {
+		name      string
+		order     binary.AppendByteOrder
+		signature string
+		build     func(w *dbusWire)
+		want      any
+	}{
+		{
+			name:      "byte",
+			signature: "y",
+			build:     func(w *dbusWire) { w.byte(7) },
+			want:      byte(7),
+		},
+		{
+			name:      "uint32 padded after byte",
+			signature: "(yu)",
+			build: func(w *dbusWire) {
+				w.byte(7)
+				w.uint32(42)
+			},
+			want: []any{byte(7), uint32(42)},
+		},
+		{
+			name:      "big endian int32",
+			order:     binary.BigEndian,
+			signature: "i",
+			build:     func(w *dbusWire) { w.uint32(0xfffffffe) },
+			want:      int32(-2),
+		},
+		{
+			name:      "uint64 padded after string",
+			signature: "(st)",
+			build: func(w *dbusWire) {
+				w.string("ab")
+				w.uint64(1 << 40)
+			},
+			want: []any{"ab", uint64(1 << 40)},
+		},
+		{
+			name:      "object path",
+			signature: "o",
+			build:     func(w *dbusWire) { w.string("/org/freedesktop/systemd1/unit/ssh_2eservice") },
+			want:      "/org/freedesktop/systemd1/unit/ssh_2eservice",
+		},
+		{
+			name:      "variant of uint32",
+			signature: "v",
+			build: func(w *dbusWire) {
+				w.signature("u")
+				w.uint32(5)
+			},
+			want: uint32(5),
+		},
+		{
+			name:      "variant of string array",
+			signature: "v",
+			build: func(w *dbusWire) {
+				w.signature("as")
+				w.array(4, func() {
+					w.string("a")
+					w.string("bc")
+				})
+			},
+			want: []any{"a", "bc"},
+		},
+		{
+			name:      "array of structs",
+			signature: "a(su)",
+			build: func(w *dbusWire) {
+				w.array(8, func() {
+					w.pad(8)
+					w.string("a")
+					w.uint32(1)
+					w.pad(8)
+					w.string("bc")
+					w.uint32(2)
+				})
+			},
+			want: []any{[]any{"a", uint32(1)}, []any{"bc", uint32(2)}},
+		},
+		{
+			name:      "empty array of uint64 keeps element padding",
+			signature: "(ata{sv}y)",
+			build: func(w *dbusWire) {
+				w.array(8, func() {})
+				w.array(8, func() {})
+				w.byte(9)
+			},
+			want: []any{[]any{}, []any{}, byte(9)},
+		},
+		{
+			name:      "dictionary of variants",
+			signature: "a{sv}",
+			build: func(w *dbusWire) {
+				w.array(8, func() {
+					w.pad(8)
+					w.string("ActiveState")
+					w.signature("s")
+					w.string("active")
+					w.pad(8)
+					w.string("NRestarts")
+					w.signature("u")
+					w.uint32(3)
+				})
+			},
+			want: []any{[]any{"ActiveState", "active"}, []any{"NRestarts", uint32(3)}},
+		},
+		{
+			name:      "boolean and double",
+			signature: "(bd)",
+			build: func(w *dbusWire) {
+				w.uint32(1)
+				w.uint64(0x3ff8000000000000)
+			},
+			want: []any{true, 1.5},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			order := tt.order
+			if order == nil {
+				order = binary.LittleEndian
+			}
+			w := &dbusWire{order: order}
+			tt.build(w)
+			d := &dbusDecoder{data: w.buf, order: order.(binary.ByteOrder)}
+			got, err := d.decode(tt.signature)
+			if err != nil {
+				t.Fatalf("decode: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("decode = %#v, want %#v", got, tt.want)
+			}
+			if d.pos != len(w.buf) {
+				t.Errorf("decoded %d of %d bytes", d.pos, len(w.buf))
+			}
+		})
+	}
+}
+
+func TestDBusDecodeErrors(t *testing.T) {
+	tests := []struct {
+		name      string
+		signature string
+		build     func(w *dbusWire)
+	}{
+		{
+			name:      "truncated string",
+			signature: "s",
+			build: func(w *dbusWire) {
+				w.uint32(10)
+				w.buf = append(w.buf, "abc"...)
+			},
+		},
+		{
+			name:      "array longer than data",
+			signature: "au",
+			build: func(w *dbusWire) {
+				w.uint32(64)
+				w.uint32(1)
+			},
+		},
+		{
+			name:      "empty variant signature",
+			signature: "v",
+			build:     func(w *dbusWire) { w.signature("") },
+		},
+		{
+			name:      "unsupported type",
+			signature: "(m)",
+			build:     func(w *dbusWire) { w.byte(0) },
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := &dbusWire{order: binary.LittleEndian}
+			tt.build(w)
+			d := &dbusDecoder{data: w.buf, order: binary.LittleEndian}
+			if got, err := d.decode(tt.signature); err == nil {
+				t.Errorf("decode = %#v, want an error", got)
+			}
+		})
+	}
+}
+
+func TestSplitDBusSignature(t *testing.T) {
+	tests := []struct {
+		signature string
+		first     string
+		rest      string
+		wantErr   bool
+	}{
+		{signature: "su", first: "s", rest: "u"},
+		{signature: "a(ssssssouso)u", first: "a(ssssssouso)", rest: "u"},
+		{signature: "a{sa(iv)}", first: "a{sa(iv)}", rest: ""},
+		{signature: "aas", first: "aas", rest: ""},
+		{signature: "(s", wantErr: true},
+		{signature: "(s}", wantErr: true},
+		{signature: "a", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		first, rest, err := splitDBusSignature(tt.signature)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("splitDBusSignature(%q) = %q, %q, want an error", tt.signature, first, rest)
+			}
+			continue
+		}
+		if err != nil || first != tt.first || rest != tt.rest {
+			t.Errorf("splitDBusSignature(%q) = %q, %q, %v, want %q, %q", tt.signature, first, rest, err, tt.first, tt.rest)
+		}
+	}
+}
+
+// dbusTestMessage builds a complete message with the given header fields.
+func dbusTestMessage(order binary.AppendByteOrder, msgType byte, replySerial uint32, errorName string, signature string, body []byte) []byte {
+	w := &dbusWire{order: order}
+	if order == binary.AppendByteOrder(binary.BigEndian) {
+		w.byte('B')
+	} else {
+		w.byte('l')
+	}
+	w.byte(msgType)
+	w.byte(0)
+	w.byte(1)
+	w.uint32(uint32(len(body)))
+	w.uint32(1000 + replySerial)
+	w.array(8, func() {
+		w.pad(8)
+		w.byte(dbusFieldReplySerial)
+		w.signature("u")
+		w.uint32(replySerial)
+		if errorName != "" {
+			w.pad(8)
+			w.byte(dbusFieldErrorName)
+			w.signature("s")
+			w.string(errorName)
+		}
+		if signature != "" {
+			w.pad(8)
+			w.byte(dbusFieldSignature)
+			w.signature("g")
+			w.signature(signature)
+		}
+	})
+	w.pad(8)
+	w.buf = append(w.buf, body...)
+	return w.buf
+}
+
+// readRawDBusMessage reads one little-endian message as sent by call.
+func readRawDBusMessage(r io.Reader) ([]byte, error) {
+	fixed := make([]byte, 16)
+	if _, err := io.ReadFull(r, fixed); err != nil {
+		return nil, err
+	}
+	headerLen := 16 + int(binary.LittleEndian.Uint32(fixed[12:]))
+	total := (headerLen+7)/8*8 + int(binary.LittleEndian.Uint32(fixed[4:]))
+	data := make([]byte, total)
+	copy(data, fixed)
+	_, err := io.ReadFull(r, data[16:])
+	return data, err
+}
+
+// serveDBus runs handler on the server end of a pipe for each request.
+func serveDBus(t *testing.T, handler func(request []byte) [][]byte) *dbusConn {
+	t.Helper()
+	client, server := net.Pipe()
+	t.Cleanup(func() {
+		client.Close()
+		server.Close()
+	})
+	go func() {
+		for {
+			request, err := readRawDBusMessage(server)
+			if err != nil {
+				return
+			}
+			for _, reply := range handler(request) {
+				if _, err := server.Write(reply); err != nil {
+					return
+				}
+			}
+		}
+	}()
+	return &dbusConn{conn: client, reader: bufio.NewReader(client)}
+}
+
+func TestDBusCallEncoding(t *testing.T) {
+	requests := make(chan []byte, 1)
+	conn := serveDBus(t, func(request []byte) [][]byte {
+		requests <- request
+		body := &dbusWire{order: binary.LittleEndian}
+		body.string("/org/freedesktop/systemd1/unit/ssh_2eservice")
+		return [][]byte{dbusTestMessage(binary.LittleEndian, dbusMethodReturn, 1, "", "o", body.buf)}
+	})
+
+	reply, err := conn.call(systemdBusName, systemdPath, systemdManager, "LoadUnit", "ssh.service")
+	if err != nil {
+		t.Fatalf("call: %v", err)
+	}
+	if !reflect.DeepEqual(reply, []any{"/org/freedesktop/systemd1/unit/ssh_2eservice"}) {
+		t.Errorf("reply = %#v", reply)
+	}
+
+	request := <-requests
+	if request[0] != 'l' || request[1] != dbusMethodCall || binary.LittleEndian.Uint32(request[8:]) != 1 {
+		t.Fatalf("fixed header = % x, want little-endian method call with serial 1", request[:16])
+	}
+	header := &dbusDecoder{data: request, order: binary.LittleEndian, pos: 12}
+	rawFields, err := header.decode("a(yv)")
+	if err != nil {
+		t.Fatalf("decode header fields: %v", err)
+	}
+	fields := map[byte]any{}
+	for _, raw := range rawFields.([]any) {
+		field := raw.([]any)
+		fields[field[0].(byte)] = field[1]
+	}
+	want := map[byte]any{
+		dbusFieldPath:        systemdPath,
+		dbusFieldInterface:   systemdManager,
+		dbusFieldMember:      "LoadUnit",
+		dbusFieldDestination: systemdBusName,
+		dbusFieldSignature:   "s",
+	}
+	if !reflect.DeepEqual(fields, want) {
+		t.Errorf("header fields = %v, want %v", fields, want)
+	}
+
+	bodyStart := (header.pos + 7) / 8 * 8
+	if strings.Trim(string(request[header.pos:bodyStart]), "\x00") != "" {
+		t.Errorf("header padding is not zero: % x", request[header.pos:bodyStart])
+	}
+	if bodyLen := int(binary.LittleEndian.Uint32(request[4:])); bodyStart+bodyLen != len(request) {
+		t.Errorf("body length %d does not match message size %d", bodyLen, len(request)-bodyStart)
+	}
+	body := &dbusDecoder{data: request, order: binary.LittleEndian, pos: bodyStart}
+	if arg, err := body.decode("s"); err != nil || arg != "ssh.service" {
+		t.Errorf("body = %#v, %v, want ssh.service", arg, err)
+	}
+}
+
+func TestDBusCallSkipsUnrelatedMessages(t *testing.T) {
+	conn := serveDBus(t, func(request []byte) [][]byte {
+		serial := binary.LittleEndian.Uint32(request[8:])
+		signal := &dbusWire{order: binary.LittleEndian}
+		signal.string(":1.42")
+		reply := &dbusWire{order: binary.BigEndian}
+		reply.signature("u")
+		reply.uint32(7)
+		return [][]byte{
+			dbusTestMessage(binary.LittleEndian, 4, 0, "", "s", signal.buf),
+			dbusTestMessage(binary.LittleEndian, dbusMethodReturn, serial+100, "", "", nil),
+			dbusTestMessage(binary.BigEndian, dbusMethodReturn, serial, "", "v", reply.buf),
+		}
+	})
+
+	reply, err := conn.call(systemdBusName, "/org/freedesktop/systemd1/unit/ssh_2eservice", dbusProperties, "Get", systemdService, "NRestarts")
+	if err != nil {
+		t.Fatalf("call: %v", err)
+	}
+	if !reflect.DeepEqual(reply, []any{uint32(7)}) {
+		t.Errorf("reply = %#v, want [7]", reply)
+	}
+}
+
+func TestDBusCallErrorReply(t *testing.T) {
+	conn := serveDBus(t, func(request []byte) [][]byte {
+		serial := binary.LittleEndian.Uint32(request[8:])
+		body := &dbusWire{order: binary.LittleEndian}
+		body.string("Unit nope.service not found.")
+		return [][]byte{dbusTestMessage(binary.LittleEndian, dbusError, serial, "org.freedesktop.systemd1.NoSuchUnit", "s", body.buf)}
+	})
+
+	_, err := conn.call(systemdBusName, systemdPath, systemdManager, "GetUnit", "nope.service")
+	if err == nil {
+		t.Fatal("call succeeded, want the error reply")
+	}
+	if want := "org.freedesktop.systemd1.NoSuchUnit: Unit nope.service not found."; err.Error() != want {
+		t.Errorf("error = %q, want %q", err, want)
+	}
+
+	// The connection stays usable after an error reply.
+	if _, err := conn.call(systemdBusName, systemdPath, systemdManager, "GetUnit", "nope.service"); err == nil {
+		t.Error("second call succeeded, want the error reply")
+	}
+}
@@ -0,0 +1,190 @@
+package metrics
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/MightyToolkit/mightymonitor-agent/internal/config"
+)
+
+const resolvConfPath = "/etc/resolv.conf"
+
+// CollectDNSChecks runs the configured DNS checks concurrently.
+func CollectDNSChecks(ctx context.Context, checks []config.DNSCheck) []DNSCheckPayload {
+	if len(checks) == 0 {
+		return nil
+	}
+	defaultResolver := defaultDNSResolver()
+	return runChecks(checks, func(check config.DNSCheck) DNSCheckPayload {
+		return runDNSCheck(ctx, check, defaultResolver)
+	})
+}
+
+func runDNSCheck(ctx context.Context, check config.DNSCheck, defaultResolver string) DNSCheckPayload {
+	qtypeName := strings.ToUpper(check.Type)
+	if qtypeName == "" {
+		qtypeName = "A"
+	}
+	result := DNSCheckPayload{
+		Name:     check.Name,
+		Query:    check.Query,
+		Type:     qtypeName,
+		Resolver: check.Resolver,
+	}
+	if result.Name == "" {
+		result.Name = qtypeName + " " + check.Query
+	}
+	if result.Resolver == "" {
+		result.Resolver = defaultResolver
+	}
+	if result.Resolver == "" {
+		result.Error = "no resolver configured and none found in " + resolvConfPath
+		return result
+	}
+	if _, _, err := net.SplitHostPort(result.Resolver); err != nil {
+		result.Resolver = net.JoinHostPort(result.Resolver, "53")
+	}
+	qtype, ok := dnsTypes[qtypeName]
+	if !ok {
+		result.Error = fmt.Sprintf("unsupported record type %q", check.Type)
+		return result
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, checkTimeout(check.TimeoutSeconds))
+	defer cancel()
+
+	start := time.Now()
+	response, err := queryDNS(ctx, result.Resolver, check.Query, qtype)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	latencyMs := durationMs(time.Since(start))
+	result.LatencyMs = &latencyMs
+	result.Rcode = response.Rcode
+	result.Answers = response.Answers
+
+	if len(check.Expect) > 0 {
+		matched := true
+		for _, expected := range check.Expect {
+			if !slices.Contains(result.Answers, normalizeDNSAnswer(expected, qtype)) {
+				matched = false
+				break
+			}
+		}
+		result.Matched = &matched
+	}
+
+	switch {
+	case result.Rcode != "NOERROR":
+		result.Error = "resolver returned " + result.Rcode
+	case result.Matched != nil && !*result.Matched:
+		result.Error = "answers did not match expect"
+	case len(result.Answers) == 0:
+		result.Error = "no " + qtypeName + " records"
+	default:
+		result.OK = true
+	}
+	return result
+}
+
+// normalizeDNSAnswer brings a configured answer into the form produced by
+// formatDNSRecord, which lowercases names and drops the trailing dot.
+func normalizeDNSAnswer(value string, qtype uint16) string {
+	if qtype == dnsTypeTXT || qtype == dnsTypeCAA {
+		return value
+	}
+	if ip := net.ParseIP(value); ip != nil {
+		return ip.String()
+	}
+	return strings.TrimSuffix(strings.ToLower(value), ".")
+}
+
+// queryDNS sends the query over UDP and retries over TCP when the answer
+// was truncated.
+func queryDNS(ctx context.Context, resolver string, name string, qtype uint16) (*dnsResponse, error) {
+	id := uint16(rand.Intn(1 << 16))
+	query, err := buildDNSQuery(id, name, qtype)
+	if err != nil {
+		return nil, err
+	}
+
+	response, err := exchangeDNS(ctx, "udp", resolver, query, id, qtype)
+	if err != nil || !response.Truncated {
+		return response, err
+	}
+	return exchangeDNS(ctx, "tcp", resolver, query, id, qtype)
+}
+
+func exchangeDNS(ctx context.Context, network string, resolver string, query []byte, id uint16, qtype uint16) (*dnsResponse, error) {
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, network, resolver)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+
+	if network == "tcp" {
+		// Messages over TCP carry a two byte length prefix.
+		framed := binary.BigEndian.AppendUint16(nil, uint16(len(query)))
+		if _, err := conn.Write(append(framed, query...)); err != nil {
+			return nil, err
+		}
+		var length [2]byte
+		if _, err := io.ReadFull(conn, length[:]); err != nil {
+			return nil, err
+		}
+		msg := make([]byte, binary.BigEndian.Uint16(length[:]))
+		if _, err := io.ReadFull(conn, msg); err != nil {
+			return nil, err
+		}
+		return parseDNSResponse(msg, id, qtype)
+	}
+
+	if _, err := conn.Write(query); err != nil {
+		return nil, err
+	}
+	buf := make([]byte, dnsEDNSSize)
+	for {
+		n, err := conn.Read(buf)
+		if err != nil {
+			return nil, err
+		}
+		response, err := parseDNSResponse(buf[:n], id, qtype)
+		if err != nil {
+			// Ignore stray or spoofed datagrams and keep waiting for ours.
+			continue
+		}
+		return response, nil
+	}
+}
+
+// defaultDNSResolver returns the first nameserver from /etc/resolv.conf.
+func defaultDNSResolver() string {
+	f, err := os.Open(resolvConfPath)
+	if err != nil {
+		return ""
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) >= 2 && fields[0] == "nameserver" {
+			return net.JoinHostPort(fields[1], "53")
+		}
+	}
+	return ""
+}
@@ -0,0 +1,244 @@
+package metrics
+
+import (
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"slices"
+	"sync/atomic"
+	"testing"
+
+	"github.com/MightyToolkit/mightymonitor-agent/internal/config"
+)
+
+type dnsQuery struct {
+	id    uint16
+	name  string
+	qtype uint16
+	tcp   bool
+}
+
+// startDNSStandIn serves DNS on the same local port over UDP and TCP. The
+// handler returns the datagrams to send back; over TCP only the last one is
+// used.
+func startDNSStandIn(t *testing.T, handle func(q dnsQuery) [][]byte) string {
+	t.Helper()
+	var udp net.PacketConn
+	var tcp net.Listener
+	for attempt := 0; tcp == nil; attempt++ {
+		var err error
+		if udp, err = net.ListenPacket("udp", "127.0.0.1:0"); err != nil {
+			t.Fatalf("listen udp: %v", err)
+		}
+		if tcp, err = net.Listen("tcp", udp.LocalAddr().String()); err != nil {
+			udp.Close()
+			if attempt == 10 {
+				t.Fatalf("listen tcp: %v", err)
+			}
+		}
+	}
+	t.Cleanup(func() {
+		udp.Close()
+		tcp.Close()
+	})
+
+	parse := func(msg []byte, overTCP bool) (dnsQuery, bool) {
+		name, offset, err := readDNSName(msg, dnsHeaderSize)
+		if err != nil || offset+2 > len(msg) {
+			return dnsQuery{}, false
+		}
+		return dnsQuery{
+			id:    binary.BigEndian.Uint16(msg),
+			name:  name,
+			qtype: binary.BigEndian.Uint16(msg[offset:]),
+			tcp:   overTCP,
+		}, true
+	}
+
+	go func() {
+		buf := make([]byte, 1500)
+		for {
+			n, from, err := udp.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			if q, ok := parse(buf[:n], false); ok {
+				for _, reply := range handle(q) {
+					udp.WriteTo(reply, from)
+				}
+			}
+		}
+	}()
+	go func() {
+		for {
+			conn, err := tcp.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				var length [2]byte
+				if _, err := io.ReadFull(conn, length[:]); err != nil {
+					return
+				}
+				msg := make([]byte, binary.BigEndian.Uint16(length[:]))
+				if _, err := io.ReadFull(conn, msg); err != nil {
+					return
+				}
+				q, ok := parse(msg, true)
+				if !ok {
+					return
+				}
+				if replies := handle(q); len(replies) > 0 {
+					reply := replies[len(replies)-1]
+					conn.Write(append(binary.BigEndian.AppendUint16(nil, uint16(len(reply))), reply...))
+				}
+			}()
+		}
+	}()
+	return udp.LocalAddr().String()
+}
+
+func TestRunDNSCheck(t *testing.T) {
+	var tcpQueries atomic.Int32
+	resolver := startDNSStandIn(t, func(q dnsQuery) [][]byte {
+		if q.tcp {
+			tcpQueries.Add(1)
+		}
+		switch q.name {
+		case "www.example.com":
+			return [][]byte{dnsTestMessage(q.id, 0, q.name, q.qtype,
+				dnsTestRR{dnsTestQuestionName, dnsTypeA, []byte{192, 0, 2, 10}},
+				dnsTestRR{dnsTestQuestionName, dnsTypeA, []byte{192, 0, 2, 11}})}
+		case "spoofed.example.com":
+			// A datagram with the wrong id arrives first and must be ignored.
+			return [][]byte{
+				dnsTestMessage(q.id+1, 0, q.name, q.qtype, dnsTestRR{dnsTestQuestionName, dnsTypeA, []byte{203, 0, 113, 66}}),
+				dnsTestMessage(q.id, 0, q.name, q.qtype, dnsTestRR{dnsTestQuestionName, dnsTypeA, []byte{192, 0, 2, 20}}),
+			}
+		case "big.example.com":
+			if !q.tcp {
+				return [][]byte{dnsTestMessage(q.id, dnsFlagTruncated, q.name, q.qtype)}
+			}
+			return [][]byte{dnsTestMessage(q.id, 0, q.name, q.qtype,
+				dnsTestRR{dnsTestQuestionName, dnsTypeTXT, []byte("\x05hello")})}
+		case "broken.example.com":
+			return [][]byte{dnsTestMessage(q.id, 2, q.name, q.qtype)}
+		case "empty.example.com":
+			return [][]byte{dnsTestMessage(q.id, 0, q.name, q.qtype)}
+		case "silent.example.com":
+			return nil
+		}
+		return [][]byte{dnsTestMessage(q.id, 3, q.name, q.qtype)}
+	})
+
+	tests := []struct {
+		name        string
+		check       config.DNSCheck
+		wantOK      bool
+		wantError   string
+		wantRcode   string
+		wantAnswers []string
+		wantMatched *bool
+	}{
+		{
+			name:        "answers match expect",
+			check:       config.DNSCheck{Query: "www.example.com", Expect: []string{"192.0.2.11"}},
+			wantOK:      true,
+			wantRcode:   "NOERROR",
+			wantAnswers: []string{"192.0.2.10", "192.0.2.11"},
+			wantMatched: boolPtr(true),
+		},
+		{
+			name:        "answers do not match expect",
+			check:       config.DNSCheck{Query: "www.example.com", Type: "a", Expect: []string{"192.0.2.99"}},
+			wantError:   "answers did not match expect",
+			wantRcode:   "NOERROR",
+			wantAnswers: []string{"192.0.2.10", "192.0.2.11"},
+			wantMatched: boolPtr(false),
+		},
+		{
+			name:        "stray datagram is ignored",
+			check:       config.DNSCheck{Query: "spoofed.example.com"},
+			wantOK:      true,
+			wantRcode:   "NOERROR",
+			wantAnswers: []string{"192.0.2.20"},
+		},
+		{
+			name:        "truncated answer retried over TCP",
+			check:       config.DNSCheck{Query: "big.example.com", Type: "TXT"},
+			wantOK:      true,
+			wantRcode:   "NOERROR",
+			wantAnswers: []string{"hello"},
+		},
+		{
+			name:      "NXDOMAIN",
+			check:     config.DNSCheck{Query: "missing.example.com"},
+			wantError: "resolver returned NXDOMAIN",
+			wantRcode: "NXDOMAIN",
+		},
+		{
+			name:      "SERVFAIL",
+			check:     config.DNSCheck{Query: "broken.example.com"},
+			wantError: "resolver returned SERVFAIL",
+			wantRcode: "SERVFAIL",
+		},
+		{
+			name:      "no records",
+			check:     config.DNSCheck{Query: "empty.example.com", Type: "AAAA"},
+			wantError: "no AAAA records",
+			wantRcode: "NOERROR",
+		},
+		{
+			name:      "unsupported type",
+			check:     config.DNSCheck{Query: "www.example.com", Type: "HINFO"},
+			wantError: `unsupported record type "HINFO"`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.check.Resolver = resolver
+			got := runDNSCheck(context.Background(), tt.check, "")
+			if got.OK != tt.wantOK || got.Error != tt.wantError || got.Rcode != tt.wantRcode {
+				t.Errorf("runDNSCheck = ok %v error %q rcode %q, want ok %v error %q rcode %q",
+					got.OK, got.Error, got.Rcode, tt.wantOK, tt.wantError, tt.wantRcode)
+			}
+			if !slices.Equal(got.Answers, tt.wantAnswers) {
+				t.Errorf("answers = %v, want %v", got.Answers, tt.wantAnswers)
+			}
+			if (got.Matched == nil) != (tt.wantMatched == nil) || (got.Matched != nil && *got.Matched != *tt.wantMatched) {
+				t.Errorf("matched = %v, want %v", got.Matched, tt.wantMatched)
+			}
+			if got.Resolver != resolver {
+				t.Errorf("resolver = %q, want %q", got.Resolver, resolver)
+			}
+		})
+	}
+	if tcpQueries.Load() != 1 {
+		t.Errorf("TCP queries = %d, want only the truncated one", tcpQueries.Load())
+	}
+
+	t.Run("timeout", func(t *testing.T) {
+		got := runDNSCheck(context.Background(), config.DNSCheck{Query: "silent.example.com", Resolver: resolver, TimeoutSeconds: 1}, "")
+		if got.OK || got.Error == "" || got.LatencyMs != nil {
+			t.Errorf("runDNSCheck = %+v, want a timeout error", got)
+		}
+	})
+
+	t.Run("default resolver", func(t *testing.T) {
+		got := runDNSCheck(context.Background(), config.DNSCheck{Query: "www.example.com"}, resolver)
+		if !got.OK || got.Resolver != resolver || got.Name != "A www.example.com" {
+			t.Errorf("runDNSCheck = %+v, want OK through the default resolver", got)
+		}
+		got = runDNSCheck(context.Background(), config.DNSCheck{Query: "www.example.com"}, "")
+		if got.OK || got.Error == "" {
+			t.Errorf("runDNSCheck without any resolver = %+v, want an error", got)
+		}
+	})
+}
+
+func boolPtr(v bool) *bool {
+	return &v
+}
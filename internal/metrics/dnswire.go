@@ -0,0 +1,258 @@
+package metrics
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net/netip"
+	"strconv"
+	"strings"
+)
+
+// This file implements the parts of the DNS wire format (RFC 1035) needed to
+// send one question and read the answers of the same type.
+
+const (
+	dnsTypeA     = 1
+	dnsTypeNS    = 2
+	dnsTypeCNAME = 5
+	dnsTypeSOA   = 6
+	dnsTypePTR   = 12
+	dnsTypeMX    = 15
+	dnsTypeTXT   = 16
+	dnsTypeAAAA  = 28
+	dnsTypeSRV   = 33
+	dnsTypeOPT   = 41
+	dnsTypeCAA   = 257
+)
+
+const (
+	dnsClassIN    = 1
+	dnsEDNSSize   = 1232
+	dnsHeaderSize = 12
+
+	dnsFlagResponse  = 1 << 15
+	dnsFlagTruncated = 1 << 9
+	dnsFlagRecursion = 1 << 8
+)
+
+var dnsTypes = map[string]uint16{
+	"A":     dnsTypeA,
+	"NS":    dnsTypeNS,
+	"CNAME": dnsTypeCNAME,
+	"SOA":   dnsTypeSOA,
+	"PTR":   dnsTypePTR,
+	"MX":    dnsTypeMX,
+	"TXT":   dnsTypeTXT,
+	"AAAA":  dnsTypeAAAA,
+	"SRV":   dnsTypeSRV,
+	"CAA":   dnsTypeCAA,
+}
+
+var dnsRcodes = []string{"NOERROR", "FORMERR", "SERVFAIL", "NXDOMAIN", "NOTIMP", "REFUSED"}
+
+var errDNSShort = errors.New("dns message truncated")
+
+type dnsResponse struct {
+	Rcode     string
+	Truncated bool
+	Answers   []string
+}
+
+func dnsRcodeName(rcode int) string {
+	if rcode < len(dnsRcodes) {
+		return dnsRcodes[rcode]
+	}
+	return "RCODE" + strconv.Itoa(rcode)
+}
+
+// buildDNSQuery encodes a recursive query for name with an EDNS0 OPT record
+// advertising a larger UDP payload, so fewer answers come back truncated.
+func buildDNSQuery(id uint16, name string, qtype uint16) ([]byte, error) {
+	msg := make([]byte, dnsHeaderSize, 512)
+	binary.BigEndian.PutUint16(msg[0:], id)
+	binary.BigEndian.PutUint16(msg[2:], dnsFlagRecursion)
+	binary.BigEndian.PutUint16(msg[4:], 1)
+	binary.BigEndian.PutUint16(msg[10:], 1)
+
+	name = strings.TrimSuffix(name, ".")
+	if len(name) > 253 {
+		return nil, fmt.Errorf("name too long: %s", name)
+	}
+	if name != "" {
+		for _, label := range strings.Split(name, ".") {
+			if label == "" || len(label) > 63 {
+				return nil, fmt.Errorf("invalid name %q", name)
+			}
+			msg = append(msg, byte(len(label)))
+			msg = append(msg, label...)
+		}
+	}
+	msg = append(msg, 0)
+	msg = binary.BigEndian.AppendUint16(msg, qtype)
+	msg = binary.BigEndian.AppendUint16(msg, dnsClassIN)
+
+	// OPT pseudo-record: root name, type, payload size as class, zero TTL
+	// and no options.
+	msg = append(msg, 0)
+	msg = binary.BigEndian.AppendUint16(msg, dnsTypeOPT)
+	msg = binary.BigEndian.AppendUint16(msg, dnsEDNSSize)
+	msg = binary.BigEndian.AppendUint32(msg, 0)
+	msg = binary.BigEndian.AppendUint16(msg, 0)
+	return msg, nil
+}
+
+// parseDNSResponse checks that msg answers query id and returns the rcode
+// and the answers of type qtype.
+func parseDNSResponse(msg []byte, id uint16, qtype uint16) (*dnsResponse, error) {
+	if len(msg) < dnsHeaderSize {
+		return nil, errDNSShort
+	}
+	if binary.BigEndian.Uint16(msg[0:]) != id {
+		return nil, errors.New("dns response id mismatch")
+	}
+	flags := binary.BigEndian.Uint16(msg[2:])
+	if flags&dnsFlagResponse == 0 {
+		return nil, errors.New("dns message is not a response")
+	}
+	result := &dnsResponse{
+		Rcode:     dnsRcodeName(int(flags & 0xF)),
+		Truncated: flags&dnsFlagTruncated != 0,
+	}
+	questions := int(binary.BigEndian.Uint16(msg[4:]))
+	answers := int(binary.BigEndian.Uint16(msg[6:]))
+
+	offset := dnsHeaderSize
+	for i := 0; i < questions; i++ {
+		var err error
+		if _, offset, err = readDNSName(msg, offset); err != nil {
+			return nil, err
+		}
+		offset += 4
+	}
+
+	for i := 0; i < answers; i++ {
+		var err error
+		if _, offset, err = readDNSName(msg, offset); err != nil {
+			return nil, err
+		}
+		if offset+10 > len(msg) {
+			return nil, errDNSShort
+		}
+		rtype := binary.BigEndian.Uint16(msg[offset:])
+		rdlength := int(binary.BigEndian.Uint16(msg[offset+8:]))
+		offset += 10
+		if offset+rdlength > len(msg) {
+			return nil, errDNSShort
+		}
+		if rtype == qtype {
+			value, err := formatDNSRecord(msg, offset, rdlength, rtype)
+			if err != nil {
+				return nil, err
+			}
+			result.Answers = append(result.Answers, value)
+		}
+		offset += rdlength
+	}
+	return result, nil
+}
+
+// readDNSName decodes a possibly compressed name at offset and returns it
+// without the trailing dot, together with the offset after the name.
+func readDNSName(msg []byte, offset int) (string, int, error) {
+	var labels []string
+	end := -1
+	for jumps := 0; ; {
+		if offset >= len(msg) {
+			return "", 0, errDNSShort
+		}
+		length := int(msg[offset])
+		switch {
+		case length == 0:
+			if end < 0 {
+				end = offset + 1
+			}
+			return strings.Join(labels, "."), end, nil
+		case length&0xC0 == 0xC0:
+			if offset+1 >= len(msg) {
+				return "", 0, errDNSShort
+			}
+			if end < 0 {
+				end = offset + 2
+			}
+			jumps++
+			if jumps > 32 {
+				return "", 0, errors.New("dns name compression loop")
+			}
+			offset = int(binary.BigEndian.Uint16(msg[offset:]) & 0x3FFF)
+		case length&0xC0 != 0:
+			return "", 0, fmt.Errorf("unsupported dns label type %#x", length)
+		default:
+			if offset+1+length > len(msg) {
+				return "", 0, errDNSShort
+			}
+			labels = append(labels, string(msg[offset+1:offset+1+length]))
+			offset += 1 + length
+		}
+	}
+}
+
+func formatDNSRecord(msg []byte, offset int, length int, rtype uint16) (string, error) {
+	rdata := msg[offset : offset+length]
+	switch rtype {
+	case dnsTypeA, dnsTypeAAAA:
+		addr, ok := netip.AddrFromSlice(rdata)
+		if !ok {
+			return "", errors.New("invalid address record")
+		}
+		return addr.String(), nil
+	case dnsTypeNS, dnsTypeCNAME, dnsTypePTR:
+		name, _, err := readDNSName(msg, offset)
+		return strings.ToLower(name), err
+	case dnsTypeMX:
+		if length < 3 {
+			return "", errDNSShort
+		}
+		name, _, err := readDNSName(msg, offset+2)
+		return fmt.Sprintf("%d %s", binary.BigEndian.Uint16(rdata), strings.ToLower(name)), err
+	case dnsTypeSRV:
+		if length < 7 {
+			return "", errDNSShort
+		}
+		name, _, err := readDNSName(msg, offset+6)
+		return fmt.Sprintf("%d %d %d %s", binary.BigEndian.Uint16(rdata), binary.BigEndian.Uint16(rdata[2:]),
+			binary.BigEndian.Uint16(rdata[4:]), strings.ToLower(name)), err
+	case dnsTypeSOA:
+		mname, next, err := readDNSName(msg, offset)
+		if err != nil {
+			return "", err
+		}
+		rname, next, err := readDNSName(msg, next)
+		if err != nil {
+			return "", err
+		}
+		if next+20 > offset+length {
+			return "", errDNSShort
+		}
+		return fmt.Sprintf("%s %s %d", strings.ToLower(mname), strings.ToLower(rname), binary.BigEndian.Uint32(msg[next:])), nil
+	case dnsTypeTXT:
+		// A record is one or more length-prefixed strings, joined here.
+		var text strings.Builder
+		for i := 0; i < len(rdata); {
+			n := int(rdata[i])
+			if i+1+n > len(rdata) {
+				return "", errDNSShort
+			}
+			text.Write(rdata[i+1 : i+1+n])
+			i += 1 + n
+		}
+		return text.String(), nil
+	case dnsTypeCAA:
+		if length < 2 || 2+int(rdata[1]) > length {
+			return "", errDNSShort
+		}
+		tagEnd := 2 + int(rdata[1])
+		return fmt.Sprintf("%d %s %q", rdata[0], rdata[2:tagEnd], rdata[tagEnd:]), nil
+	}
+	return fmt.Sprintf("%x", rdata), nil
+}
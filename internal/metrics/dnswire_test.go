@@ -0,0 +1,241 @@
+package metrics
+
+import (
+	"encoding/binary"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type dnsTestRR struct {
+	name  []byte
+	rtype uint16
+	rdata []byte
+}
+
+// dnsTestQuestionName is a compression pointer to the question name, which
+// always starts right after the header.
+var dnsTestQuestionName = []byte{0xC0, dnsHeaderSize}
+
+func dnsTestName(name string) []byte {
+	var encoded []byte
+	for _, label := range strings.Split(strings.TrimSuffix(name, "."), ".") {
+		if label == "" {
+			continue
+		}
+		encoded = append(encoded, byte(len(label)))
+		encoded = append(encoded, label...)
+	}
+	return append(encoded, 0)
+}
+
+// dnsTestMessage builds a response with one question and the given answers.
+func dnsTestMessage(id uint16, flags uint16, qname string, qtype uint16, answers ...dnsTestRR) []byte {
+	msg := binary.BigEndian.AppendUint16(nil, id)
+	msg = binary.BigEndian.AppendUint16(msg, dnsFlagResponse|flags)
+	msg = binary.BigEndian.AppendUint16(msg, 1)
+	msg = binary.BigEndian.AppendUint16(msg, uint16(len(answers)))
+	msg = binary.BigEndian.AppendUint32(msg, 0)
+	msg = append(msg, dnsTestName(qname)...)
+	msg = binary.BigEndian.AppendUint16(msg, qtype)
+	msg = binary.BigEndian.AppendUint16(msg, dnsClassIN)
+	for _, rr := range answers {
+		msg = append(msg, rr.name...)
+		msg = binary.BigEndian.AppendUint16(msg, rr.rtype)
+		msg = binary.BigEndian.AppendUint16(msg, dnsClassIN)
+		msg = binary.BigEndian.AppendUint32(msg, 300)
+		msg = binary.BigEndian.AppendUint16(msg, uint16(len(rr.rdata)))
+		msg = append(msg, rr.rdata...)
+	}
+	return msg
+}
+
+func TestBuildDNSQuery(t *testing.T) {
+	query, err := buildDNSQuery(0x1234, "Example.COM.", dnsTypeMX)
+	if err != nil {
+		t.Fatalf("buildDNSQuery: %v", err)
+	}
+	header := []byte{0x12, 0x34, 0x01, 0x00, 0, 1, 0, 0, 0, 0, 0, 1}
+	if !reflect.DeepEqual(query[:dnsHeaderSize], header) {
+		t.Errorf("header = % x, want % x", query[:dnsHeaderSize], header)
+	}
+	name, offset, err := readDNSName(query, dnsHeaderSize)
+	if err != nil || name != "Example.COM" {
+		t.Fatalf("question name = %q, %v", name, err)
+	}
+	if qtype := binary.BigEndian.Uint16(query[offset:]); qtype != dnsTypeMX {
+		t.Errorf("question type = %d, want MX", qtype)
+	}
+	opt := query[offset+4:]
+	if len(opt) != 11 || opt[0] != 0 || binary.BigEndian.Uint16(opt[1:]) != dnsTypeOPT || binary.BigEndian.Uint16(opt[3:]) != dnsEDNSSize {
+		t.Errorf("OPT record = % x", opt)
+	}
+
+	for _, name := range []string{strings.Repeat("a", 64) + ".com", "a..com", strings.Repeat("abcdefghi.", 26) + "com"} {
+		if _, err := buildDNSQuery(1, name, dnsTypeA); err == nil {
+			t.Errorf("buildDNSQuery(%q) succeeded, want an error", name)
+		}
+	}
+}
+
+func TestParseDNSResponse(t *testing.T) {
+	mxRdata := append([]byte{0, 10}, dnsTestQuestionName...)
+	// Serial 2025 followed by the four other 32-bit SOA fields.
+	soaRdata := append(dnsTestName("ns1.example.com"), dnsTestName("hostmaster.example.com")...)
+	soaRdata = append(soaRdata, 0, 0, 0x07, 0xe9)
+	soaRdata = append(soaRdata, make([]byte, 16)...)
+	// mail.example.com with "example.com" compressed to the second label of
+	// the question name www.example.com.
+	mailRdata := append([]byte{0, 20, 4, 'm', 'a', 'i', 'l'}, 0xC0, dnsHeaderSize+4)
+
+	tests := []struct {
+		name  string
+		msg   []byte
+		qtype uint16
+		want  *dnsResponse
+	}{
+		{
+			name:  "A with compressed owner names",
+			qtype: dnsTypeA,
+			msg: dnsTestMessage(7, 0, "www.example.com", dnsTypeA,
+				dnsTestRR{dnsTestQuestionName, dnsTypeA, []byte{192, 0, 2, 1}},
+				dnsTestRR{dnsTestQuestionName, dnsTypeA, []byte{192, 0, 2, 2}}),
+			want: &dnsResponse{Rcode: "NOERROR", Answers: []string{"192.0.2.1", "192.0.2.2"}},
+		},
+		{
+			name:  "CNAME chain only returns the requested type",
+			qtype: dnsTypeA,
+			msg: dnsTestMessage(7, 0, "www.example.com", dnsTypeA,
+				dnsTestRR{dnsTestQuestionName, dnsTypeCNAME, dnsTestName("web.example.net")},
+				dnsTestRR{dnsTestName("web.example.net"), dnsTypeA, []byte{198, 51, 100, 7}}),
+			want: &dnsResponse{Rcode: "NOERROR", Answers: []string{"198.51.100.7"}},
+		},
+		{
+			name:  "MX with compressed exchange names",
+			qtype: dnsTypeMX,
+			msg: dnsTestMessage(7, 0, "www.example.com", dnsTypeMX,
+				dnsTestRR{dnsTestQuestionName, dnsTypeMX, mxRdata},
+				dnsTestRR{dnsTestQuestionName, dnsTypeMX, mailRdata}),
+			want: &dnsResponse{Rcode: "NOERROR", Answers: []string{"10 www.example.com", "20 mail.example.com"}},
+		},
+		{
+			name:  "AAAA",
+			qtype: dnsTypeAAAA,
+			msg: dnsTestMessage(7, 0, "example.com", dnsTypeAAAA,
+				dnsTestRR{dnsTestQuestionName, dnsTypeAAAA, []byte{0x20, 0x01, 0x0d, 0xb8, 15: 1}}),
+			want: &dnsResponse{Rcode: "NOERROR", Answers: []string{"2001:db8::1"}},
+		},
+		{
+			name:  "TXT joins character strings",
+			qtype: dnsTypeTXT,
+			msg: dnsTestMessage(7, 0, "example.com", dnsTypeTXT,
+				dnsTestRR{dnsTestQuestionName, dnsTypeTXT, []byte("\x06v=spf1\x05 -all")}),
+			want: &dnsResponse{Rcode: "NOERROR", Answers: []string{"v=spf1 -all"}},
+		},
+		{
+			name:  "SRV",
+			qtype: dnsTypeSRV,
+			msg: dnsTestMessage(7, 0, "_sip._tcp.example.com", dnsTypeSRV,
+				dnsTestRR{dnsTestQuestionName, dnsTypeSRV, append([]byte{0, 1, 0, 5, 0x13, 0xc4}, dnsTestName("SIP.example.com")...)}),
+			want: &dnsResponse{Rcode: "NOERROR", Answers: []string{"1 5 5060 sip.example.com"}},
+		},
+		{
+			name:  "SOA",
+			qtype: dnsTypeSOA,
+			msg: dnsTestMessage(7, 0, "example.com", dnsTypeSOA,
+				dnsTestRR{dnsTestQuestionName, dnsTypeSOA, soaRdata}),
+			want: &dnsResponse{Rcode: "NOERROR", Answers: []string{"ns1.example.com hostmaster.example.com 2025"}},
+		},
+		{
+			name:  "CAA",
+			qtype: dnsTypeCAA,
+			msg: dnsTestMessage(7, 0, "example.com", dnsTypeCAA,
+				dnsTestRR{dnsTestQuestionName, dnsTypeCAA, []byte("\x00\x05issueletsencrypt.org")}),
+			want: &dnsResponse{Rcode: "NOERROR", Answers: []string{`0 issue "letsencrypt.org"`}},
+		},
+		{
+			name:  "NXDOMAIN",
+			qtype: dnsTypeA,
+			msg:   dnsTestMessage(7, 3, "missing.example.com", dnsTypeA),
+			want:  &dnsResponse{Rcode: "NXDOMAIN"},
+		},
+		{
+			name:  "SERVFAIL",
+			qtype: dnsTypeA,
+			msg:   dnsTestMessage(7, 2, "broken.example.com", dnsTypeA),
+			want:  &dnsResponse{Rcode: "SERVFAIL"},
+		},
+		{
+			name:  "unknown rcode",
+			qtype: dnsTypeA,
+			msg:   dnsTestMessage(7, 9, "example.com", dnsTypeA),
+			want:  &dnsResponse{Rcode: "RCODE9"},
+		},
+		{
+			name:  "truncated flag",
+			qtype: dnsTypeTXT,
+			msg:   dnsTestMessage(7, dnsFlagTruncated, "example.com", dnsTypeTXT),
+			want:  &dnsResponse{Rcode: "NOERROR", Truncated: true},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseDNSResponse(tt.msg, 7, tt.qtype)
+			if err != nil {
+				t.Fatalf("parseDNSResponse: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseDNSResponse = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseDNSResponseErrors(t *testing.T) {
+	valid := dnsTestMessage(7, 0, "example.com", dnsTypeA,
+		dnsTestRR{dnsTestQuestionName, dnsTypeA, []byte{192, 0, 2, 1}})
+
+	notResponse := append([]byte(nil), valid...)
+	notResponse[2] &^= dnsFlagResponse >> 8
+
+	loop := dnsTestMessage(7, 0, "example.com", dnsTypeCNAME,
+		dnsTestRR{dnsTestQuestionName, dnsTypeCNAME, []byte{0xC0, 0}})
+	// Point the CNAME target at itself.
+	loop[len(loop)-1] = byte(len(loop) - 2)
+
+	tests := []struct {
+		name  string
+		msg   []byte
+		id    uint16
+		qtype uint16
+		want  error
+	}{
+		{name: "short header", msg: valid[:10], id: 7, qtype: dnsTypeA, want: errDNSShort},
+		{name: "cut in question", msg: valid[:dnsHeaderSize+5], id: 7, qtype: dnsTypeA, want: errDNSShort},
+		{name: "cut in answer header", msg: valid[:len(valid)-8], id: 7, qtype: dnsTypeA, want: errDNSShort},
+		{name: "cut in rdata", msg: valid[:len(valid)-1], id: 7, qtype: dnsTypeA, want: errDNSShort},
+		{name: "id mismatch", msg: valid, id: 8, qtype: dnsTypeA},
+		{name: "not a response", msg: notResponse, id: 7, qtype: dnsTypeA},
+		{name: "compression loop", msg: loop, id: 7, qtype: dnsTypeCNAME},
+		{
+			name:  "invalid address length",
+			msg:   dnsTestMessage(7, 0, "example.com", dnsTypeA, dnsTestRR{dnsTestQuestionName, dnsTypeA, []byte{1, 2, 3}}),
+			id:    7,
+			qtype: dnsTypeA,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseDNSResponse(tt.msg, tt.id, tt.qtype)
+			if err == nil {
+				t.Fatalf("parseDNSResponse = %+v, want an error", got)
+			}
+			if tt.want != nil && !errors.Is(err, tt.want) {
+				t.Errorf("error = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,273 @@
+package metrics
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	DefaultDockerSocket = "/var/run/docker.sock"
+	dockerTimeout       = 10 * time.Second
+)
+
+// DockerClient talks to the Docker Engine API over its Unix socket.
+type DockerClient struct {
+	httpClient *http.Client
+}
+
+func NewDockerClient(socketPath string) *DockerClient {
+	transport := &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var dialer net.Dialer
+			return dialer.DialContext(ctx, "unix", socketPath)
+		},
+	}
+	return &DockerClient{
+		httpClient: &http.Client{Transport: transport, Timeout: dockerTimeout},
+	}
+}
+
+type dockerContainerSummary struct {
+	ID     string   `json:"Id"`
+	Names  []string `json:"Names"`
+	Image  string   `json:"Image"`
+	State  string   `json:"State"`
+	Status string   `json:"Status"`
+}
+
+type dockerContainerInspect struct {
+	ID           string `json:"Id"`
+	Name         string `json:"Name"`
+	RestartCount int    `json:"RestartCount"`
+	Config       struct {
+		Image string `json:"Image"`
+	} `json:"Config"`
+	State struct {
+		Status     string `json:"Status"`
+		Running    bool   `json:"Running"`
+		OOMKilled  bool   `json:"OOMKilled"`
+		ExitCode   int    `json:"ExitCode"`
+		StartedAt  string `json:"StartedAt"`
+		FinishedAt string `json:"FinishedAt"`
+		Health     *struct {
+			Status        string `json:"Status"`
+			FailingStreak int    `json:"FailingStreak"`
+		} `json:"Health"`
+	} `json:"State"`
+}
+
+func (c *DockerClient) ListContainers(ctx context.Context) ([]dockerContainerSummary, error) {
+	var containers []dockerContainerSummary
+	if err := c.get(ctx, "/containers/json?all=1", &containers); err != nil {
+		return nil, err
+	}
+	return containers, nil
+}
+
+func (c *DockerClient) InspectContainer(ctx context.Context, id string) (*dockerContainerInspect, error) {
+	var container dockerContainerInspect
+	if err := c.get(ctx, "/containers/"+url.PathEscape(id)+"/json", &container); err != nil {
+		return nil, err
+	}
+	return &container, nil
+}
+
+func (c *DockerClient) get(ctx context.Context, path string, out any) error {
+	// The host part is ignored by the Unix socket dialer.
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "http://docker"+path, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 16<<20))
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("docker %s: http %d: %s", path, resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+	return json.Unmarshal(body, out)
+}
+
+type dockerContainerState struct {
+	Name         string `json:"name"`
+	Image        string `json:"image,omitempty"`
+	State        string `json:"state"`
+	Health       string `json:"health,omitempty"`
+	RestartCount int    `json:"restartCount"`
+	FinishedAt   string `json:"finishedAt,omitempty"`
+}
+
+type dockerState struct {
+	Containers map[string]dockerContainerState `json:"containers"`
+}
+
+// CollectDocker lists containers through the Docker Engine API and returns
+// their state together with events for containers that died, restarted or
+// became unhealthy since the last committed state, which is staged anew in
+// pending. Containers that disappeared are reported as died when they were
+// last seen running, e.g. a --rm container, and as removed otherwise.
+// Returns nil when the socket does not exist, i.e. Docker is not installed.
+func CollectDocker(stateDir string, socketPath string, pending *PendingState) ([]DockerContainerPayload, []EventPayload, error) {
+	if socketPath == "" {
+		socketPath = DefaultDockerSocket
+	}
+	if _, err := os.Stat(socketPath); err != nil {
+		return nil, nil, nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), dockerTimeout)
+	defer cancel()
+
+	client := NewDockerClient(socketPath)
+	summaries, err := client.ListContainers(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	containers := make([]DockerContainerPayload, 0, len(summaries))
+	current := dockerState{Containers: make(map[string]dockerContainerState, len(summaries))}
+	for _, summary := range summaries {
+		inspect, err := client.InspectContainer(ctx, summary.ID)
+		if err != nil {
+			// Removed between list and inspect.
+			continue
+		}
+
+		container := DockerContainerPayload{
+			ID:           shortContainerID(inspect.ID),
+			Name:         strings.TrimPrefix(inspect.Name, "/"),
+			Image:        summary.Image,
+			State:        inspect.State.Status,
+			Status:       summary.Status,
+			RestartCount: inspect.RestartCount,
+			ExitCode:     inspect.State.ExitCode,
+			OOMKilled:    inspect.State.OOMKilled,
+			StartedAt:    inspect.State.StartedAt,
+			FinishedAt:   inspect.State.FinishedAt,
+		}
+		if container.Image == "" {
+			container.Image = inspect.Config.Image
+		}
+		if inspect.State.Health != nil {
+			container.Health = inspect.State.Health.Status
+		}
+		containers = append(containers, container)
+		current.Containers[inspect.ID] = dockerContainerState{
+			Name:         container.Name,
+			Image:        container.Image,
+			State:        container.State,
+			Health:       container.Health,
+			RestartCount: container.RestartCount,
+			FinishedAt:   container.FinishedAt,
+		}
+	}
+
+	stateFile := filepath.Join(stateDir, "docker_state.json")
+	var prev dockerState
+	prevErr := loadState(stateFile, &prev)
+	pending.stage(stateFile, current)
+	if prevErr != nil {
+		return containers, nil, nil
+	}
+
+	now := time.Now().Unix()
+	var events []EventPayload
+	byID := make(map[string]DockerContainerPayload, len(containers))
+	for _, container := range containers {
+		byID[container.ID] = container
+	}
+	for _, id := range sortedKeys(current.Containers) {
+		cur := current.Containers[id]
+		old, ok := prev.Containers[id]
+		if !ok {
+			continue
+		}
+		container := byID[shortContainerID(id)]
+		details := map[string]any{
+			"id":           container.ID,
+			"name":         container.Name,
+			"image":        container.Image,
+			"state":        container.State,
+			"exitCode":     container.ExitCode,
+			"oomKilled":    container.OOMKilled,
+			"restartCount": container.RestartCount,
+		}
+
+		switch {
+		case old.State == "running" && (cur.State == "exited" || cur.State == "dead"):
+			events = append(events, EventPayload{
+				Type:    "container_died",
+				TS:      now,
+				Message: fmt.Sprintf("container %s exited with code %d", cur.Name, container.ExitCode),
+				Details: details,
+			})
+		case cur.RestartCount > old.RestartCount || (cur.State == "running" && old.FinishedAt != cur.FinishedAt):
+			events = append(events, EventPayload{
+				Type:    "container_restarted",
+				TS:      now,
+				Message: fmt.Sprintf("container %s restarted (last exit code %d)", cur.Name, container.ExitCode),
+				Details: details,
+			})
+		}
+
+		if cur.Health == "unhealthy" && old.Health != "unhealthy" {
+			events = append(events, EventPayload{
+				Type:    "container_unhealthy",
+				TS:      now,
+				Message: fmt.Sprintf("container %s became unhealthy", cur.Name),
+				Details: details,
+			})
+		}
+	}
+	for _, id := range sortedKeys(prev.Containers) {
+		if _, ok := current.Containers[id]; ok {
+			continue
+		}
+		old := prev.Containers[id]
+		details := map[string]any{
+			"id":      shortContainerID(id),
+			"name":    old.Name,
+			"image":   old.Image,
+			"state":   old.State,
+			"removed": true,
+		}
+		if old.State == "running" {
+			events = append(events, EventPayload{
+				Type:    "container_died",
+				TS:      now,
+				Message: fmt.Sprintf("container %s disappeared while running", old.Name),
+				Details: details,
+			})
+			continue
+		}
+		events = append(events, EventPayload{
+			Type:    "container_removed",
+			TS:      now,
+			Message: fmt.Sprintf("container %s was removed", old.Name),
+			Details: details,
+		})
+	}
+	return containers, events, nil
+}
+
+func shortContainerID(id string) string {
+	if len(id) > 12 {
+		return id[:12]
+	}
+	return id
+}
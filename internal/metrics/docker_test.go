@@ -0,0 +1,206 @@
+package metrics
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+type fakeDockerContainer struct {
+	id           string
+	name         string
+	image        string
+	state        string
+	health       string
+	restartCount int
+	exitCode     int
+	finishedAt   string
+}
+
+// fakeDocker serves the container list and inspect endpoints of the Docker
+// Engine API on a Unix socket.
+type fakeDocker struct {
+	mu         sync.Mutex
+	containers []fakeDockerContainer
+}
+
+func (f *fakeDocker) set(containers ...fakeDockerContainer) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.containers = containers
+}
+
+func (f *fakeDocker) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if r.URL.Path == "/containers/json" {
+		if r.URL.Query().Get("all") != "1" {
+			http.Error(w, "expected all=1", http.StatusBadRequest)
+			return
+		}
+		list := []map[string]any{}
+		for _, c := range f.containers {
+			list = append(list, map[string]any{
+				"Id":     c.id,
+				"Names":  []string{"/" + c.name},
+				"Image":  c.image,
+				"State":  c.state,
+				"Status": c.state,
+			})
+		}
+		json.NewEncoder(w).Encode(list)
+		return
+	}
+
+	id, ok := strings.CutPrefix(r.URL.Path, "/containers/")
+	id, ok2 := strings.CutSuffix(id, "/json")
+	if !ok || !ok2 {
+		http.NotFound(w, r)
+		return
+	}
+	for _, c := range f.containers {
+		if c.id != id {
+			continue
+		}
+		state := map[string]any{
+			"Status":     c.state,
+			"Running":    c.state == "running",
+			"ExitCode":   c.exitCode,
+			"StartedAt":  "2026-01-01T00:00:00Z",
+			"FinishedAt": c.finishedAt,
+		}
+		if c.health != "" {
+			state["Health"] = map[string]any{"Status": c.health}
+		}
+		json.NewEncoder(w).Encode(map[string]any{
+			"Id":           c.id,
+			"Name":         "/" + c.name,
+			"RestartCount": c.restartCount,
+			"Config":       map[string]any{"Image": c.image},
+			"State":        state,
+		})
+		return
+	}
+	http.Error(w, `{"message":"No such container"}`, http.StatusNotFound)
+}
+
+func startFakeDocker(t *testing.T) (*fakeDocker, string) {
+	t.Helper()
+	socket := filepath.Join(t.TempDir(), "docker.sock")
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	fake := &fakeDocker{}
+	server := &http.Server{Handler: fake}
+	go server.Serve(listener)
+	t.Cleanup(func() { server.Close() })
+	return fake, socket
+}
+
+func dockerID(prefix string) string {
+	return prefix + strings.Repeat("0", 64-len(prefix))
+}
+
+func eventsByType(events []EventPayload) map[string][]string {
+	byType := map[string][]string{}
+	for _, event := range events {
+		byType[event.Type] = append(byType[event.Type], event.Details["name"].(string))
+	}
+	return byType
+}
+
+func TestCollectDocker(t *testing.T) {
+	fake, socket := startFakeDocker(t)
+	stateDir := t.TempDir()
+
+	web := fakeDockerContainer{id: dockerID("a1"), name: "web", image: "nginx:1.27", state: "running", health: "healthy", finishedAt: "0001-01-01T00:00:00Z"}
+	worker := fakeDockerContainer{id: dockerID("b2"), name: "worker", image: "app:3", state: "running", finishedAt: "0001-01-01T00:00:00Z"}
+	cron := fakeDockerContainer{id: dockerID("c3"), name: "cron", image: "app:3", state: "running", finishedAt: "0001-01-01T00:00:00Z"}
+	job := fakeDockerContainer{id: dockerID("d4"), name: "job", image: "app:3", state: "running"}
+	old := fakeDockerContainer{id: dockerID("e5"), name: "old", image: "app:2", state: "exited", exitCode: 0}
+	fake.set(web, worker, cron, job, old)
+
+	pending := &PendingState{}
+	containers, events, err := CollectDocker(stateDir, socket, pending)
+	if err != nil {
+		t.Fatalf("first CollectDocker: %v", err)
+	}
+	if len(containers) != 5 || len(events) != 0 {
+		t.Fatalf("first run = %d containers, events %+v, want 5 containers and no events", len(containers), events)
+	}
+	if containers[0].ID != "a10000000000" || containers[0].Name != "web" || containers[0].Health != "healthy" {
+		t.Errorf("container = %+v", containers[0])
+	}
+	if err := pending.Commit(); err != nil {
+		t.Fatalf("commit: %v", err)
+	}
+
+	web.health = "unhealthy"
+	worker.state, worker.exitCode, worker.finishedAt = "exited", 137, "2026-01-02T00:00:00Z"
+	cron.restartCount, cron.finishedAt = 1, "2026-01-02T00:00:00Z"
+	added := fakeDockerContainer{id: dockerID("f6"), name: "added", image: "redis:7", state: "running"}
+	// job was a --rm container that exited; old was removed by hand.
+	fake.set(web, worker, cron, added)
+
+	pending = &PendingState{}
+	_, events, err = CollectDocker(stateDir, socket, pending)
+	if err != nil {
+		t.Fatalf("second CollectDocker: %v", err)
+	}
+	got := eventsByType(events)
+	want := map[string][]string{
+		"container_died":      {"worker", "job"},
+		"container_restarted": {"cron"},
+		"container_unhealthy": {"web"},
+		"container_removed":   {"old"},
+	}
+	if len(got) != len(want) {
+		t.Errorf("events = %v, want %v", got, want)
+	}
+	for eventType, names := range want {
+		if strings.Join(got[eventType], ",") != strings.Join(names, ",") {
+			t.Errorf("%s events for %v, want %v", eventType, got[eventType], names)
+		}
+	}
+	for _, event := range events {
+		if event.Details["name"] == "worker" && event.Details["exitCode"] != 137 {
+			t.Errorf("worker died event details = %v, want exit code 137", event.Details)
+		}
+		if event.Details["name"] == "job" && (event.Details["removed"] != true || event.Details["image"] != "app:3") {
+			t.Errorf("job died event details = %v, want removed with its image", event.Details)
+		}
+	}
+
+	// Without a commit the same changes are reported again.
+	_, repeated, err := CollectDocker(stateDir, socket, &PendingState{})
+	if err != nil {
+		t.Fatalf("repeated CollectDocker: %v", err)
+	}
+	if len(repeated) != len(events) {
+		t.Errorf("uncommitted run reported %d events, want the same %d", len(repeated), len(events))
+	}
+
+	if err := pending.Commit(); err != nil {
+		t.Fatalf("commit: %v", err)
+	}
+	_, events, err = CollectDocker(stateDir, socket, &PendingState{})
+	if err != nil {
+		t.Fatalf("third CollectDocker: %v", err)
+	}
+	if len(events) != 0 {
+		t.Errorf("events after commit = %v, want none", eventsByType(events))
+	}
+}
+
+func TestCollectDockerWithoutSocket(t *testing.T) {
+	containers, events, err := CollectDocker(t.TempDir(), filepath.Join(t.TempDir(), "missing.sock"), &PendingState{})
+	if containers != nil || events != nil || err != nil {
+		t.Errorf("CollectDocker = %v, %v, %v, want nothing when Docker is not installed", containers, events, err)
+	}
+}
@@ -0,0 +1,112 @@
+package metrics
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/MightyToolkit/mightymonitor-agent/internal/config"
+)
+
+// CollectFileChecks evaluates the configured file and directory checks.
+func CollectFileChecks(checks []config.FileCheck) []FileCheckPayload {
+	if len(checks) == 0 {
+		return nil
+	}
+	now := time.Now()
+	results := make([]FileCheckPayload, 0, len(checks))
+	for _, check := range checks {
+		results = append(results, runFileCheck(check, now))
+	}
+	return results
+}
+
+func runFileCheck(check config.FileCheck, now time.Time) FileCheckPayload {
+	result := FileCheckPayload{Name: check.Name, Path: check.Path}
+	if result.Name == "" {
+		result.Name = check.Path
+	}
+
+	info, err := os.Stat(check.Path)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+
+	newest := info
+	if info.IsDir() {
+		newest, result.Count, err = newestFileInDir(check.Path, check.Pattern)
+		if err != nil {
+			result.Error = err.Error()
+			return result
+		}
+		if newest != nil {
+			result.NewestFile = newest.Name()
+		}
+	}
+
+	if newest != nil {
+		age := int64(now.Sub(newest.ModTime()).Seconds())
+		size := newest.Size()
+		result.AgeSeconds = &age
+		result.SizeBytes = &size
+	} else if check.MaxAgeSeconds > 0 || check.MinSizeBytes > 0 || check.MaxSizeBytes > 0 {
+		result.Failures = append(result.Failures, "no matching files")
+	}
+
+	if result.AgeSeconds != nil && check.MaxAgeSeconds > 0 && *result.AgeSeconds > check.MaxAgeSeconds {
+		result.Failures = append(result.Failures, fmt.Sprintf("age %ds exceeds %ds", *result.AgeSeconds, check.MaxAgeSeconds))
+	}
+	if result.SizeBytes != nil && check.MinSizeBytes > 0 && *result.SizeBytes < check.MinSizeBytes {
+		result.Failures = append(result.Failures, fmt.Sprintf("size %d bytes below %d", *result.SizeBytes, check.MinSizeBytes))
+	}
+	if result.SizeBytes != nil && check.MaxSizeBytes > 0 && *result.SizeBytes > check.MaxSizeBytes {
+		result.Failures = append(result.Failures, fmt.Sprintf("size %d bytes exceeds %d", *result.SizeBytes, check.MaxSizeBytes))
+	}
+	if result.Count != nil && check.MinCount > 0 && *result.Count < check.MinCount {
+		result.Failures = append(result.Failures, fmt.Sprintf("count %d below %d", *result.Count, check.MinCount))
+	}
+	if result.Count != nil && check.MaxCount > 0 && *result.Count > check.MaxCount {
+		result.Failures = append(result.Failures, fmt.Sprintf("count %d exceeds %d", *result.Count, check.MaxCount))
+	}
+	result.OK = len(result.Failures) == 0
+	return result
+}
+
+// newestFileInDir returns the most recently modified regular file directly
+// inside dir whose name matches pattern, and the number of such files.
+// Subdirectories are not descended into.
+func newestFileInDir(dir string, pattern string) (os.FileInfo, *int, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var newest os.FileInfo
+	count := 0
+	for _, entry := range entries {
+		if !entry.Type().IsRegular() {
+			continue
+		}
+		if pattern != "" {
+			matched, err := filepath.Match(pattern, entry.Name())
+			if err != nil {
+				return nil, nil, fmt.Errorf("invalid pattern: %w", err)
+			}
+			if !matched {
+				continue
+			}
+		}
+		info, err := entry.Info()
+		if err != nil {
+			// Removed since the directory was read, e.g. a consumed queue item.
+			continue
+		}
+		count++
+		if newest == nil || info.ModTime().After(newest.ModTime()) {
+			newest = info
+		}
+	}
+	return newest, &count, nil
+}
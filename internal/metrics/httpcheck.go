@@ -0,0 +1,181 @@
+package metrics
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptrace"
+	"regexp"
+	"sync"
+	"time"
+
+	"github.com/MightyToolkit/mightymonitor-agent/internal/config"
+)
+
+const maxHTTPCheckBodyMatch = 1 << 20
+
+// CollectHTTPChecks runs the configured HTTP checks concurrently. Each check
+// stops at its own timeout or when ctx is done, whichever comes first.
+func CollectHTTPChecks(ctx context.Context, checks []config.HTTPCheck) []HTTPCheckPayload {
+	return runChecks(checks, func(check config.HTTPCheck) HTTPCheckPayload {
+		return runHTTPCheck(ctx, check)
+	})
+}
+
+// httpPhases records httptrace callbacks. With dual-stack hosts several
+// connection attempts may race, so only the first start and the first
+// completion of each phase are kept.
+type httpPhases struct {
+	mu           sync.Mutex
+	dnsStart     time.Time
+	dnsDone      time.Time
+	connectStart time.Time
+	connectDone  time.Time
+	tlsStart     time.Time
+	tlsDone      time.Time
+	firstByte    time.Time
+}
+
+func (p *httpPhases) mark(t *time.Time) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if t.IsZero() {
+		*t = time.Now()
+	}
+}
+
+func (p *httpPhases) trace() *httptrace.ClientTrace {
+	return &httptrace.ClientTrace{
+		DNSStart: func(httptrace.DNSStartInfo) { p.mark(&p.dnsStart) },
+		DNSDone:  func(httptrace.DNSDoneInfo) { p.mark(&p.dnsDone) },
+		ConnectStart: func(string, string) {
+			p.mark(&p.connectStart)
+		},
+		ConnectDone: func(_ string, _ string, err error) {
+			if err == nil {
+				p.mark(&p.connectDone)
+			}
+		},
+		TLSHandshakeStart: func() { p.mark(&p.tlsStart) },
+		TLSHandshakeDone: func(_ tls.ConnectionState, err error) {
+			if err == nil {
+				p.mark(&p.tlsDone)
+			}
+		},
+		GotFirstResponseByte: func() { p.mark(&p.firstByte) },
+	}
+}
+
+func phaseMs(start time.Time, end time.Time) *float64 {
+	if start.IsZero() || end.IsZero() {
+		return nil
+	}
+	ms := durationMs(end.Sub(start))
+	return &ms
+}
+
+func runHTTPCheck(ctx context.Context, check config.HTTPCheck) HTTPCheckPayload {
+	result := HTTPCheckPayload{Name: check.Name, URL: check.URL}
+	if result.Name == "" {
+		result.Name = check.URL
+	}
+
+	var pattern *regexp.Regexp
+	if check.BodyPattern != "" {
+		var err error
+		pattern, err = regexp.Compile(check.BodyPattern)
+		if err != nil {
+			result.Error = fmt.Sprintf("invalid body_pattern: %v", err)
+			return result
+		}
+	}
+
+	method := check.Method
+	if method == "" {
+		method = http.MethodGet
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, checkTimeout(check.TimeoutSeconds))
+	defer cancel()
+
+	phases := &httpPhases{}
+	req, err := http.NewRequestWithContext(httptrace.WithClientTrace(ctx, phases.trace()), method, check.URL, nil)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	for name, value := range check.Headers {
+		if http.CanonicalHeaderKey(name) == "Host" {
+			req.Host = value
+			continue
+		}
+		req.Header.Set(name, value)
+	}
+
+	// A fresh connection per run so every phase is measured.
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DisableKeepAlives = true
+	defer transport.CloseIdleConnections()
+	client := &http.Client{
+		Transport: transport,
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+
+	start := time.Now()
+	resp, err := client.Do(req)
+	if err != nil {
+		result.TotalMs = durationMs(time.Since(start))
+		result.Error = err.Error()
+		fillHTTPPhases(&result, phases, start)
+		return result
+	}
+	defer resp.Body.Close()
+
+	var head bytes.Buffer
+	n, err := io.Copy(&head, io.LimitReader(resp.Body, maxHTTPCheckBodyMatch))
+	if err == nil {
+		var rest int64
+		rest, err = io.Copy(io.Discard, resp.Body)
+		n += rest
+	}
+	result.TotalMs = durationMs(time.Since(start))
+	result.StatusCode = resp.StatusCode
+	result.ResponseBytes = n
+	fillHTTPPhases(&result, phases, start)
+	if err != nil {
+		result.Error = fmt.Sprintf("read body: %v", err)
+		return result
+	}
+
+	if check.ExpectStatus != 0 {
+		result.OK = resp.StatusCode == check.ExpectStatus
+	} else {
+		result.OK = resp.StatusCode >= 200 && resp.StatusCode < 400
+	}
+	if !result.OK {
+		result.Error = fmt.Sprintf("unexpected status %d", resp.StatusCode)
+	}
+	if pattern != nil {
+		matched := pattern.Match(head.Bytes())
+		result.BodyMatched = &matched
+		if !matched && result.OK {
+			result.OK = false
+			result.Error = "body did not match body_pattern"
+		}
+	}
+	return result
+}
+
+func fillHTTPPhases(result *HTTPCheckPayload, phases *httpPhases, start time.Time) {
+	phases.mu.Lock()
+	defer phases.mu.Unlock()
+	result.DNSMs = phaseMs(phases.dnsStart, phases.dnsDone)
+	result.ConnectMs = phaseMs(phases.connectStart, phases.connectDone)
+	result.TLSMs = phaseMs(phases.tlsStart, phases.tlsDone)
+	result.TTFBMs = phaseMs(start, phases.firstByte)
+}
@@ -0,0 +1,265 @@
+package metrics
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/MightyToolkit/mightymonitor-agent/internal/config"
+)
+
+const (
+	defaultIntegrityMaxFiles = 5000
+	defaultIntegrityMaxBytes = 256 << 20
+)
+
+type fileBaseline struct {
+	SHA256 string `json:"sha256"`
+	Size   int64  `json:"size"`
+	Mtime  int64  `json:"mtime"`
+	Mode   string `json:"mode"`
+	UID    uint32 `json:"uid"`
+	GID    uint32 `json:"gid"`
+}
+
+type integrityState struct {
+	Files map[string]fileBaseline `json:"files"`
+	// Cursor is the last file hashed by a run that hit the limits; the next
+	// run starts after it.
+	Cursor string `json:"cursor,omitempty"`
+}
+
+// CollectFileIntegrity hashes the configured files and directory trees with
+// SHA-256 and returns events for files added, removed or modified since the
+// last committed baseline; the new baseline is staged in pending. Content,
+// mode and ownership changes are all reported as modifications. Files beyond
+// the per-run file or byte limits keep their previous baseline; each run
+// continues after the last file hashed by the previous one, so trees larger
+// than the limits are covered over several runs.
+func CollectFileIntegrity(stateDir string, cfg *config.FileIntegrityConfig, pending *PendingState) ([]EventPayload, error) {
+	if cfg == nil || len(cfg.Paths) == 0 {
+		return nil, nil
+	}
+	maxFiles := cfg.MaxFiles
+	if maxFiles <= 0 {
+		maxFiles = defaultIntegrityMaxFiles
+	}
+	maxBytes := cfg.MaxBytes
+	if maxBytes <= 0 {
+		maxBytes = defaultIntegrityMaxBytes
+	}
+
+	stateFile := filepath.Join(stateDir, "integrity_state.json")
+	var prev integrityState
+	prevErr := loadState(stateFile, &prev)
+	if prev.Files == nil {
+		prev.Files = map[string]fileBaseline{}
+	}
+
+	files, err := listWatchedFiles(cfg.Paths)
+	if err != nil {
+		return nil, err
+	}
+
+	// Start after the cursor and wrap around, so files beyond the limits get
+	// their turn on later runs instead of the same tail always being skipped.
+	start := sort.Search(len(files), func(i int) bool { return files[i] > prev.Cursor })
+	files = slices.Concat(files[start:], files[:start])
+
+	current := integrityState{Files: make(map[string]fileBaseline, len(files))}
+	skipped := map[string]bool{}
+	var hashedFiles int
+	var hashedBytes int64
+	var lastHashed string
+	for _, path := range files {
+		info, err := os.Lstat(path)
+		if err != nil {
+			continue
+		}
+		if hashedFiles >= maxFiles || hashedBytes+info.Size() > maxBytes {
+			skipped[path] = true
+			if old, ok := prev.Files[path]; ok {
+				current.Files[path] = old
+			}
+			continue
+		}
+
+		baseline, err := hashFile(path, info)
+		if err != nil {
+			log.Printf("WARN metrics file integrity hash failed: path=%s err=%v", path, err)
+			skipped[path] = true
+			if old, ok := prev.Files[path]; ok {
+				current.Files[path] = old
+			}
+			continue
+		}
+		hashedFiles++
+		hashedBytes += info.Size()
+		lastHashed = path
+		current.Files[path] = baseline
+	}
+	if len(skipped) > 0 {
+		current.Cursor = prev.Cursor
+		if lastHashed != "" {
+			current.Cursor = lastHashed
+		}
+		log.Printf("WARN metrics file integrity limits reached: %d of %d files not checked this run (max_files=%d max_bytes=%d)", len(skipped), len(files), maxFiles, maxBytes)
+	}
+
+	pending.stage(stateFile, current)
+	if prevErr != nil {
+		return nil, nil
+	}
+
+	now := time.Now().Unix()
+	var events []EventPayload
+	for _, path := range sortedKeys(current.Files) {
+		if skipped[path] {
+			continue
+		}
+		file := current.Files[path]
+		old, existed := prev.Files[path]
+		if !existed {
+			events = append(events, EventPayload{
+				Type:    "file_added",
+				TS:      now,
+				Message: "file added: " + path,
+				Details: fileEventDetails(path, file),
+			})
+			continue
+		}
+
+		var changes []string
+		if file.SHA256 != old.SHA256 {
+			changes = append(changes, "content")
+		}
+		if file.Mode != old.Mode {
+			changes = append(changes, "mode")
+		}
+		if file.UID != old.UID || file.GID != old.GID {
+			changes = append(changes, "owner")
+		}
+		if len(changes) == 0 {
+			continue
+		}
+
+		details := fileEventDetails(path, file)
+		details["changes"] = changes
+		details["previousSha256"] = old.SHA256
+		details["previousMtime"] = old.Mtime
+		details["previousMode"] = old.Mode
+		details["previousUid"] = old.UID
+		details["previousGid"] = old.GID
+		events = append(events, EventPayload{
+			Type:    "file_modified",
+			TS:      now,
+			Message: fmt.Sprintf("file modified (%s): %s", strings.Join(changes, ", "), path),
+			Details: details,
+		})
+	}
+
+	for _, path := range sortedKeys(prev.Files) {
+		if _, ok := current.Files[path]; ok || skipped[path] || !isWatchedPath(path, cfg.Paths) {
+			continue
+		}
+		events = append(events, EventPayload{
+			Type:    "file_removed",
+			TS:      now,
+			Message: "file removed: " + path,
+			Details: fileEventDetails(path, prev.Files[path]),
+		})
+	}
+	return events, nil
+}
+
+// listWatchedFiles expands the configured paths into a sorted list of
+// regular files. Symlinks are not followed.
+func listWatchedFiles(paths []string) ([]string, error) {
+	seen := map[string]bool{}
+	for _, root := range paths {
+		root = filepath.Clean(root)
+		err := filepath.WalkDir(root, func(path string, entry fs.DirEntry, err error) error {
+			if err != nil {
+				if path == root && os.IsNotExist(err) {
+					return nil
+				}
+				log.Printf("WARN metrics file integrity walk failed: path=%s err=%v", path, err)
+				if entry != nil && entry.IsDir() {
+					return fs.SkipDir
+				}
+				return nil
+			}
+			if entry.Type().IsRegular() {
+				seen[path] = true
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	files := make([]string, 0, len(seen))
+	for path := range seen {
+		files = append(files, path)
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+func isWatchedPath(path string, roots []string) bool {
+	for _, root := range roots {
+		root = filepath.Clean(root)
+		if path == root || strings.HasPrefix(path, root+string(filepath.Separator)) {
+			return true
+		}
+	}
+	return false
+}
+
+func hashFile(path string, info os.FileInfo) (fileBaseline, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return fileBaseline{}, err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return fileBaseline{}, err
+	}
+
+	baseline := fileBaseline{
+		SHA256: hex.EncodeToString(h.Sum(nil)),
+		Size:   info.Size(),
+		Mtime:  info.ModTime().Unix(),
+		Mode:   info.Mode().String(),
+	}
+	if stat, ok := info.Sys().(*syscall.Stat_t); ok {
+		baseline.UID = stat.Uid
+		baseline.GID = stat.Gid
+	}
+	return baseline, nil
+}
+
+func fileEventDetails(path string, file fileBaseline) map[string]any {
+	return map[string]any{
+		"path":   path,
+		"sha256": file.SHA256,
+		"size":   file.Size,
+		"mtime":  file.Mtime,
+		"mode":   file.Mode,
+		"uid":    file.UID,
+		"gid":    file.GID,
+	}
+}
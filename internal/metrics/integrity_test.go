@@ -0,0 +1,120 @@
+package metrics
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/MightyToolkit/mightymonitor-agent/internal/config"
+)
+
+func TestCollectFileIntegrity(t *testing.T) {
+	stateDir := t.TempDir()
+	root := t.TempDir()
+	write := func(name, content string) string {
+		path := filepath.Join(root, name)
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	a := write("a.conf", "a")
+	b := write("b.conf", "b")
+	write("c.conf", "c")
+
+	run := func(cfg *config.FileIntegrityConfig) []EventPayload {
+		t.Helper()
+		pending := &PendingState{}
+		events, err := CollectFileIntegrity(stateDir, cfg, pending)
+		if err != nil {
+			t.Fatalf("CollectFileIntegrity: %v", err)
+		}
+		if err := pending.Commit(); err != nil {
+			t.Fatalf("commit: %v", err)
+		}
+		return events
+	}
+
+	cfg := &config.FileIntegrityConfig{Paths: []string{root}}
+	if events := run(cfg); len(events) != 0 {
+		t.Fatalf("first run events = %v, want none", events)
+	}
+
+	write("b.conf", "changed")
+	os.Remove(a)
+	write("d.conf", "d")
+	got := map[string]string{}
+	for _, event := range run(cfg) {
+		got[event.Details["path"].(string)] = event.Type
+	}
+	want := map[string]string{a: "file_removed", b: "file_modified", filepath.Join(root, "d.conf"): "file_added"}
+	if len(got) != len(want) {
+		t.Errorf("events = %v, want %v", got, want)
+	}
+	for path, eventType := range want {
+		if got[path] != eventType {
+			t.Errorf("%s event = %q, want %q", path, got[path], eventType)
+		}
+	}
+}
+
+func TestCollectFileIntegrityRotatesWhenLimited(t *testing.T) {
+	stateDir := t.TempDir()
+	root := t.TempDir()
+	var paths []string
+	for _, name := range []string{"a", "b", "c", "d", "e"} {
+		path := filepath.Join(root, name)
+		if err := os.WriteFile(path, []byte(name), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		paths = append(paths, path)
+	}
+
+	cfg := &config.FileIntegrityConfig{Paths: []string{root}, MaxFiles: 2}
+	run := func() []EventPayload {
+		t.Helper()
+		pending := &PendingState{}
+		events, err := CollectFileIntegrity(stateDir, cfg, pending)
+		if err != nil {
+			t.Fatalf("CollectFileIntegrity: %v", err)
+		}
+		if err := pending.Commit(); err != nil {
+			t.Fatalf("commit: %v", err)
+		}
+		return events
+	}
+
+	// The first three runs hash a+b, c+d, then e+a; afterwards every file
+	// has a baseline and changes anywhere in the tree are picked up.
+	run()
+	for i, want := range [][]string{paths[2:4], paths[4:]} {
+		events := run()
+		var added []string
+		for _, event := range events {
+			if event.Type == "file_added" {
+				added = append(added, event.Details["path"].(string))
+			}
+		}
+		if len(events) != len(want) || !slices.Equal(added, want) {
+			t.Fatalf("run %d events = %v, want %v added", i+2, events, want)
+		}
+	}
+
+	if err := os.WriteFile(paths[3], []byte("changed"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	var modified []string
+	for i := 0; i < 3 && len(modified) == 0; i++ {
+		for _, event := range run() {
+			if event.Type != "file_modified" {
+				t.Errorf("unexpected event %s for %v", event.Type, event.Details["path"])
+				continue
+			}
+			modified = append(modified, event.Details["path"].(string))
+		}
+	}
+	if len(modified) != 1 || modified[0] != paths[3] {
+		t.Errorf("modified = %v, want %s within a full rotation", modified, paths[3])
+	}
+}
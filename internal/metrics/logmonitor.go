@@ -0,0 +1,101 @@
+package metrics
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"time"
+
+	"github.com/MightyToolkit/mightymonitor-agent/internal/config"
+)
+
+const (
+	maxLogBytesPerRun  = 16 << 20
+	maxLogRuleSamples  = 3
+	maxLogSampleLength = 512
+)
+
+type logRuleMatcher struct {
+	pattern *regexp.Regexp
+	payload *LogRulePayload
+}
+
+// CollectLogMonitors tails the configured log files since the last committed
+// read positions and counts the lines matching each rule. The new positions
+// are staged in pending and survive rotation (see readNewLines). When more
+// than maxLogBytesPerRun was appended the result is marked partial; the rest
+// is read on the next run.
+func CollectLogMonitors(stateDir string, monitors []config.LogMonitor, pending *PendingState) ([]LogMonitorPayload, error) {
+	if len(monitors) == 0 {
+		return nil, nil
+	}
+
+	stateFile := filepath.Join(stateDir, "logs_state.json")
+	var prev tailState
+	hasPrev := loadState(stateFile, &prev) == nil
+
+	now := time.Now().Unix()
+	current := tailState{TS: now, Files: make(map[string]fileOffset, len(monitors))}
+	var window *int64
+	if hasPrev && prev.TS > 0 && now >= prev.TS {
+		elapsed := now - prev.TS
+		window = &elapsed
+	}
+
+	results := make([]LogMonitorPayload, 0, len(monitors))
+	for _, monitor := range monitors {
+		result := LogMonitorPayload{Path: monitor.Path, Rules: make([]LogRulePayload, len(monitor.Rules))}
+		var matchers []logRuleMatcher
+		for i, rule := range monitor.Rules {
+			result.Rules[i] = LogRulePayload{Name: rule.Name, Severity: rule.Severity}
+			pattern, err := regexp.Compile(rule.Pattern)
+			if err != nil {
+				result.Rules[i].Error = fmt.Sprintf("invalid pattern: %v", err)
+				continue
+			}
+			matchers = append(matchers, logRuleMatcher{pattern: pattern, payload: &result.Rules[i]})
+		}
+
+		var prevOffset *fileOffset
+		if offset, ok := prev.Files[monitor.Path]; ok {
+			prevOffset = &offset
+		}
+		offset, limited, err := readNewLines(monitor.Path, prevOffset, maxLogBytesPerRun, func(line string) {
+			result.LinesRead++
+			for _, matcher := range matchers {
+				if !matcher.pattern.MatchString(line) {
+					continue
+				}
+				matcher.payload.Matches++
+				if len(matcher.payload.Samples) < maxLogRuleSamples {
+					matcher.payload.Samples = append(matcher.payload.Samples, truncateLogSample(line))
+				}
+			}
+		})
+		if err != nil {
+			result.Error = err.Error()
+			// Keep the old position so a file that is briefly missing during
+			// rotation is resumed rather than restarted at its end.
+			if prevOffset != nil {
+				current.Files[monitor.Path] = *prevOffset
+			}
+		} else {
+			current.Files[monitor.Path] = offset
+			result.Partial = limited
+			if prevOffset != nil && !limited {
+				result.WindowSeconds = window
+			}
+		}
+		results = append(results, result)
+	}
+
+	pending.stage(stateFile, current)
+	return results, nil
+}
+
+func truncateLogSample(line string) string {
+	if len(line) <= maxLogSampleLength {
+		return line
+	}
+	return line[:maxLogSampleLength]
+}
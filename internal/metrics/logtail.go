@@ -0,0 +1,201 @@
+package metrics
+
+import (
+	"bufio"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"os"
+	"syscall"
+)
+
+const fingerprintSize = 256
+
+// fileOffset is the persisted read position in an append-only file. Inode
+// identifies the file so a replaced file is read from the start again.
+// HeadHash is a hash of the first HeadLen bytes; it tells a file truncated
+// and refilled past Offset (copytruncate) from one that was only appended
+// to, and recognises a compressed rotated copy.
+type fileOffset struct {
+	Inode    uint64 `json:"inode"`
+	Offset   int64  `json:"offset"`
+	HeadLen  int64  `json:"headLen,omitempty"`
+	HeadHash string `json:"headHash,omitempty"`
+}
+
+// tailState is the persisted state of collectors that tail several files:
+// the time of the previous run and the read position per path.
+type tailState struct {
+	TS    int64                 `json:"ts"`
+	Files map[string]fileOffset `json:"files"`
+}
+
+// readNewLines calls fn for every complete line appended to path since prev
+// and returns the position to persist for the next call. Without a previous
+// position reading starts at the end of the file, so history is not counted
+// on first run. A file that was replaced or truncated is read from the
+// start, after finishing the rotated copy (see readRotatedRemainder). At most
+// maxBytes are consumed per call from each file; the rest of the current file
+// is picked up next time, and limited reports that this happened. A trailing
+// line without newline is left for the next call.
+func readNewLines(path string, prev *fileOffset, maxBytes int64, fn func(line string)) (fileOffset, bool, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return fileOffset{}, false, err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return fileOffset{}, false, err
+	}
+	current := fileOffset{Inode: fileInode(info), Offset: info.Size()}
+
+	limited := false
+	if prev != nil {
+		start := prev.Offset
+		truncated := prev.Inode == current.Inode &&
+			(start > info.Size() || !matchesFingerprint(io.NewSectionReader(f, 0, info.Size()), *prev))
+		if prev.Inode != current.Inode || truncated {
+			start = 0
+			limited, err = readRotatedRemainder(path, *prev, truncated, maxBytes, fn)
+			if err != nil {
+				return fileOffset{}, false, err
+			}
+		}
+		if _, err := f.Seek(start, io.SeekStart); err != nil {
+			return fileOffset{}, false, err
+		}
+		var more bool
+		current.Offset, more, err = scanLines(f, start, maxBytes, fn)
+		if err != nil {
+			return fileOffset{}, false, err
+		}
+		limited = limited || more
+	}
+
+	current.HeadLen = min(current.Offset, fingerprintSize)
+	current.HeadHash, err = fingerprint(io.NewSectionReader(f, 0, current.HeadLen), current.HeadLen)
+	if err != nil {
+		return fileOffset{}, false, err
+	}
+	return current, limited, nil
+}
+
+// readRotatedRemainder reads the lines that were appended after prev but
+// before the file was rotated, so they are not lost:
+//   - rename rotation: the old file is now path.1, recognised by its inode,
+//     or path.1.gz if it was compressed right away;
+//   - copytruncate: path itself was truncated, and path.1 (or path.1.gz) is
+//     a copy of the old content.
+//
+// Copies and compressed files must also match the fingerprint in prev.
+// Without a matching rotated file nothing is read. It reports whether lines
+// were left unread because of maxBytes.
+func readRotatedRemainder(path string, prev fileOffset, truncated bool, maxBytes int64, fn func(line string)) (bool, error) {
+	rotated := path + ".1"
+	if info, err := os.Stat(rotated); err == nil {
+		if (!truncated && fileInode(info) != prev.Inode) || info.Size() < prev.Offset {
+			return false, nil
+		}
+		f, err := os.Open(rotated)
+		if err != nil {
+			return false, err
+		}
+		defer f.Close()
+		if truncated && !matchesFingerprint(f, prev) {
+			return false, nil
+		}
+		if _, err := f.Seek(prev.Offset, io.SeekStart); err != nil {
+			return false, err
+		}
+		_, limited, err := scanLines(f, prev.Offset, maxBytes, fn)
+		return limited, err
+	}
+
+	// Only consulted without path.1, since with delaycompress path.1.gz is
+	// an older generation.
+	f, err := os.Open(rotated + ".gz")
+	if err != nil {
+		if os.IsNotExist(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	defer f.Close()
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		return false, err
+	}
+	defer gz.Close()
+	if prev.HeadHash == "" || !matchesFingerprint(gz, prev) {
+		return false, nil
+	}
+	if _, err := io.CopyN(io.Discard, gz, prev.Offset-prev.HeadLen); err != nil {
+		if errors.Is(err, io.EOF) {
+			return false, nil
+		}
+		return false, err
+	}
+	_, limited, err := scanLines(gz, prev.Offset, maxBytes, fn)
+	return limited, err
+}
+
+// matchesFingerprint consumes the first prev.HeadLen bytes of r and reports
+// whether they hash to prev.HeadHash. Positions saved without a fingerprint
+// always match.
+func matchesFingerprint(r io.Reader, prev fileOffset) bool {
+	if prev.HeadHash == "" {
+		return true
+	}
+	hash, err := fingerprint(r, prev.HeadLen)
+	return err == nil && hash == prev.HeadHash
+}
+
+func fingerprint(r io.Reader, n int64) (string, error) {
+	hash := sha256.New()
+	if _, err := io.CopyN(hash, r, n); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// scanLines reads complete lines from r, which is positioned at offset, and
+// returns the offset just past the last complete line consumed. It reports
+// whether it stopped at maxBytes with more data left in r.
+func scanLines(r io.Reader, offset int64, maxBytes int64, fn func(line string)) (int64, bool, error) {
+	reader := bufio.NewReaderSize(r, 64*1024)
+	var consumed int64
+	for maxBytes <= 0 || consumed < maxBytes {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return offset + consumed, false, nil
+			}
+			return offset + consumed, false, err
+		}
+		consumed += int64(len(line))
+		fn(trimLineEnding(line))
+	}
+	_, err := reader.Peek(1)
+	return offset + consumed, err == nil, nil
+}
+
+func trimLineEnding(line string) string {
+	if n := len(line); n > 0 && line[n-1] == '\n' {
+		line = line[:n-1]
+	}
+	if n := len(line); n > 0 && line[n-1] == '\r' {
+		line = line[:n-1]
+	}
+	return line
+}
+
+func fileInode(info os.FileInfo) uint64 {
+	if stat, ok := info.Sys().(*syscall.Stat_t); ok {
+		return uint64(stat.Ino)
+	}
+	return 0
+}
@@ -0,0 +1,144 @@
+package metrics
+
+import (
+	"bytes"
+	"compress/gzip"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestReadNewLinesLimit(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "app.log")
+	writeLogFile(t, path, "start\n")
+	offset, limited, err := readNewLines(path, nil, 10, func(string) { t.Error("first call read history") })
+	if err != nil || limited {
+		t.Fatalf("first readNewLines = %+v, %v, %v", offset, limited, err)
+	}
+
+	read := func(maxBytes int64) ([]string, bool) {
+		t.Helper()
+		var lines []string
+		next, limited, err := readNewLines(path, &offset, maxBytes, func(line string) { lines = append(lines, line) })
+		if err != nil {
+			t.Fatalf("readNewLines: %v", err)
+		}
+		offset = next
+		return lines, limited
+	}
+
+	appendLogFile(t, path, "one\ntwo\nthree\nfour\n")
+	if lines, limited := read(8); !slices.Equal(lines, []string{"one", "two"}) || !limited {
+		t.Errorf("limited read = %v, limited %v, want one and two with more left", lines, limited)
+	}
+	if lines, limited := read(8); !slices.Equal(lines, []string{"three", "four"}) || limited {
+		t.Errorf("second read = %v, limited %v, want the rest", lines, limited)
+	}
+
+	appendLogFile(t, path, "five\nsix")
+	if lines, limited := read(0); !slices.Equal(lines, []string{"five"}) || limited {
+		t.Errorf("unlimited read = %v, limited %v, want five without the unfinished line", lines, limited)
+	}
+}
+
+func TestReadNewLinesRotation(t *testing.T) {
+	tests := []struct {
+		name   string
+		rotate func(t *testing.T, path string)
+	}{
+		{
+			name: "rename",
+			rotate: func(t *testing.T, path string) {
+				if err := os.Rename(path, path+".1"); err != nil {
+					t.Fatal(err)
+				}
+				writeLogFile(t, path, "c\n")
+			},
+		},
+		{
+			name: "copytruncate",
+			rotate: func(t *testing.T, path string) {
+				content, err := os.ReadFile(path)
+				if err != nil {
+					t.Fatal(err)
+				}
+				writeLogFile(t, path+".1", string(content))
+				if err := os.Truncate(path, 0); err != nil {
+					t.Fatal(err)
+				}
+				appendLogFile(t, path, "c\n")
+			},
+		},
+		{
+			name: "compressed right away",
+			rotate: func(t *testing.T, path string) {
+				content, err := os.ReadFile(path)
+				if err != nil {
+					t.Fatal(err)
+				}
+				var compressed bytes.Buffer
+				gz := gzip.NewWriter(&compressed)
+				gz.Write(content)
+				gz.Close()
+				writeLogFile(t, path+".1.gz", compressed.String())
+				writeLogFile(t, path+".new", "c\n")
+				if err := os.Rename(path+".new", path); err != nil {
+					t.Fatal(err)
+				}
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "app.log")
+			writeLogFile(t, path, "before the agent started\n")
+			offset, _, err := readNewLines(path, nil, 0, func(string) {})
+			if err != nil {
+				t.Fatalf("first readNewLines: %v", err)
+			}
+
+			appendLogFile(t, path, "a\nb\n")
+			tt.rotate(t, path)
+
+			var lines []string
+			next, limited, err := readNewLines(path, &offset, 0, func(line string) { lines = append(lines, line) })
+			if err != nil {
+				t.Fatalf("readNewLines after rotation: %v", err)
+			}
+			if !slices.Equal(lines, []string{"a", "b", "c"}) || limited {
+				t.Errorf("lines = %v, limited %v, want the rotated remainder a, b and the new c", lines, limited)
+			}
+
+			// The rotated copy is not read again.
+			appendLogFile(t, path, "d\n")
+			lines = nil
+			if _, _, err := readNewLines(path, &next, 0, func(line string) { lines = append(lines, line) }); err != nil {
+				t.Fatalf("readNewLines: %v", err)
+			}
+			if !slices.Equal(lines, []string{"d"}) {
+				t.Errorf("lines = %v, want only d", lines)
+			}
+		})
+	}
+}
+
+func writeLogFile(t *testing.T, path string, content string) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func appendLogFile(t *testing.T, path string, content string) {
+	t.Helper()
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if _, err := f.WriteString(content); err != nil {
+		t.Fatal(err)
+	}
+}
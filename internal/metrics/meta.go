@@ -1,10 +1,16 @@
 package metrics
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
 	"errors"
 	"os"
+	"path/filepath"
+	"runtime"
 	"strconv"
 	"strings"
+	"syscall"
 )
 
 func GetHostname() string {
@@ -34,3 +40,220 @@ func GetUptime() (*int64, error) {
 	uptime := int64(uptimeFloat)
 	return &uptime, nil
 }
+
+// CollectInventory gathers slow-changing host facts, including cloud instance
+// metadata cached in stateDir. Every field is best-effort; anything that
+// cannot be read is left empty.
+func CollectInventory(stateDir string) *InventoryPayload {
+	osRelease := readOSRelease()
+	dmi := readDMI()
+	inventory := &InventoryPayload{
+		OSID:           osRelease["ID"],
+		OSName:         osRelease["NAME"],
+		OSVersion:      osRelease["VERSION_ID"],
+		OSPrettyName:   osRelease["PRETTY_NAME"],
+		BootID:         readTrimmedFile("/proc/sys/kernel/random/boot_id"),
+		MachineID:      readTrimmedFile("/etc/machine-id"),
+		Virtualization: detectVirtualization(dmi),
+		Container:      detectContainer(),
+		Timezone:       detectTimezone(),
+		Cloud:          CollectCloudMetadata(stateDir, NewCloudMetadataClient()),
+	}
+	if inventory.OSVersion == "" {
+		inventory.OSVersion = osRelease["VERSION"]
+	}
+
+	var uts syscall.Utsname
+	if err := syscall.Uname(&uts); err == nil {
+		inventory.KernelRelease = utsnameString(uts.Release[:])
+		inventory.Architecture = utsnameString(uts.Machine[:])
+	}
+	if inventory.Architecture == "" {
+		inventory.Architecture = runtime.GOARCH
+	}
+
+	inventory.Hash = inventoryHash(inventory)
+	return inventory
+}
+
+type inventoryState struct {
+	Hash string `json:"hash"`
+}
+
+// InventoryChanged reports whether inventory differs from the last inventory
+// recorded with SaveInventoryState or committed after StageInventoryState.
+func InventoryChanged(stateDir string, inventory *InventoryPayload) bool {
+	var prev inventoryState
+	if err := loadState(filepath.Join(stateDir, "inventory_state.json"), &prev); err != nil {
+		return true
+	}
+	return prev.Hash != inventory.Hash
+}
+
+func SaveInventoryState(stateDir string, inventory *InventoryPayload) error {
+	return saveState(filepath.Join(stateDir, "inventory_state.json"), inventoryState{Hash: inventory.Hash})
+}
+
+// StageInventoryState records inventory in pending, to be saved once the
+// payload reporting it has been accepted.
+func StageInventoryState(pending *PendingState, stateDir string, inventory *InventoryPayload) {
+	pending.stage(filepath.Join(stateDir, "inventory_state.json"), inventoryState{Hash: inventory.Hash})
+}
+
+func inventoryHash(inventory *InventoryPayload) string {
+	clone := *inventory
+	clone.Hash = ""
+	data, err := json.Marshal(clone)
+	if err != nil {
+		return ""
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func readOSRelease() map[string]string {
+	values := map[string]string{}
+	content, err := os.ReadFile("/etc/os-release")
+	if err != nil {
+		content, err = os.ReadFile("/usr/lib/os-release")
+		if err != nil {
+			return values
+		}
+	}
+
+	for _, line := range strings.Split(string(content), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		key, value, ok := strings.Cut(line, "=")
+		if !ok {
+			continue
+		}
+		if unquoted, err := strconv.Unquote(value); err == nil {
+			value = unquoted
+		} else {
+			value = strings.Trim(value, `"'`)
+		}
+		values[key] = value
+	}
+	return values
+}
+
+type dmiInfo struct {
+	SysVendor     string
+	ProductName   string
+	ProductVer    string
+	BIOSVendor    string
+	BoardVendor   string
+	ChassisAsset  string
+	ProductSerial string
+}
+
+func readDMI() dmiInfo {
+	const dir = "/sys/class/dmi/id/"
+	return dmiInfo{
+		SysVendor:     readTrimmedFile(dir + "sys_vendor"),
+		ProductName:   readTrimmedFile(dir + "product_name"),
+		ProductVer:    readTrimmedFile(dir + "product_version"),
+		BIOSVendor:    readTrimmedFile(dir + "bios_vendor"),
+		BoardVendor:   readTrimmedFile(dir + "board_vendor"),
+		ChassisAsset:  readTrimmedFile(dir + "chassis_asset_tag"),
+		ProductSerial: readTrimmedFile(dir + "product_serial"),
+	}
+}
+
+// detectVirtualization maps DMI vendor strings to the identifiers used by
+// systemd-detect-virt. Returns "none" for bare metal and "" when unknown.
+func detectVirtualization(dmi dmiInfo) string {
+	vendors := strings.ToLower(strings.Join([]string{dmi.SysVendor, dmi.ProductName, dmi.BIOSVendor, dmi.BoardVendor}, " "))
+	switch {
+	case strings.Contains(vendors, "amazon ec2"):
+		return "amazon"
+	case strings.Contains(vendors, "google"):
+		return "google"
+	case strings.Contains(vendors, "microsoft corporation") && strings.Contains(vendors, "virtual machine"):
+		return "microsoft"
+	case strings.Contains(vendors, "vmware"):
+		return "vmware"
+	case strings.Contains(vendors, "virtualbox"), strings.Contains(vendors, "innotek"):
+		return "oracle"
+	case strings.Contains(vendors, "parallels"):
+		return "parallels"
+	case strings.Contains(vendors, "xen"):
+		return "xen"
+	case strings.Contains(vendors, "kvm"), strings.Contains(vendors, "qemu"),
+		strings.Contains(vendors, "hetzner"), strings.Contains(vendors, "digitalocean"):
+		return "kvm"
+	case strings.Contains(vendors, "bochs"):
+		return "bochs"
+	}
+
+	if hypervisor := readTrimmedFile("/sys/hypervisor/type"); hypervisor != "" {
+		return hypervisor
+	}
+	if strings.TrimSpace(vendors) != "" {
+		return "none"
+	}
+	return ""
+}
+
+// detectContainer reports the container runtime the agent runs in, or "" when
+// it runs directly on the host.
+func detectContainer() string {
+	if value := readTrimmedFile("/run/systemd/container"); value != "" {
+		return value
+	}
+	if _, err := os.Stat("/.dockerenv"); err == nil {
+		return "docker"
+	}
+	if _, err := os.Stat("/run/.containerenv"); err == nil {
+		return "podman"
+	}
+
+	cgroup := readTrimmedFile("/proc/1/cgroup")
+	switch {
+	case strings.Contains(cgroup, "kubepods"):
+		return "kubernetes"
+	case strings.Contains(cgroup, "docker"):
+		return "docker"
+	case strings.Contains(cgroup, "libpod"):
+		return "podman"
+	case strings.Contains(cgroup, "lxc"):
+		return "lxc"
+	}
+	return ""
+}
+
+func detectTimezone() string {
+	if tz := readTrimmedFile("/etc/timezone"); tz != "" {
+		return tz
+	}
+	if target, err := os.Readlink("/etc/localtime"); err == nil {
+		if _, name, ok := strings.Cut(target, "zoneinfo/"); ok {
+			return name
+		}
+	}
+	return os.Getenv("TZ")
+}
+
+func readTrimmedFile(path string) string {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(content))
+}
+
+// utsnameString converts a NUL-terminated utsname field. The element type is
+// int8 or uint8 depending on the architecture.
+func utsnameString[T int8 | uint8](field []T) string {
+	buf := make([]byte, 0, len(field))
+	for _, c := range field {
+		if c == 0 {
+			break
+		}
+		buf = append(buf, byte(c))
+	}
+	return string(buf)
+}
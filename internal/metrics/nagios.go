@@ -0,0 +1,196 @@
+package metrics
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/MightyToolkit/mightymonitor-agent/internal/config"
+)
+
+const maxNagiosOutput = 64 << 10
+
+var nagiosStatuses = []string{"OK", "WARNING", "CRITICAL", "UNKNOWN"}
+
+var perfdataValuePattern = regexp.MustCompile(`^([-+]?(?:[0-9]+\.?[0-9]*|\.[0-9]+)(?:[eE][-+]?[0-9]+)?)([^;0-9]*)$`)
+
+// CollectNagiosChecks runs the configured plugins concurrently. A plugin is
+// killed at its own timeout or when ctx is done.
+func CollectNagiosChecks(ctx context.Context, checks []config.NagiosCheck) []NagiosCheckPayload {
+	return runChecks(checks, func(check config.NagiosCheck) NagiosCheckPayload {
+		return runNagiosCheck(ctx, check)
+	})
+}
+
+func runNagiosCheck(ctx context.Context, check config.NagiosCheck) NagiosCheckPayload {
+	result := NagiosCheckPayload{Name: check.Name, Status: "UNKNOWN", ExitCode: 3}
+	if len(check.Command) == 0 {
+		result.Message = "no command configured"
+		return result
+	}
+	if result.Name == "" {
+		result.Name = check.Command[0]
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, checkTimeout(check.TimeoutSeconds))
+	defer cancel()
+
+	// Plugins often fork helpers, so the whole process group is killed on
+	// timeout rather than only the direct child.
+	cmd := exec.CommandContext(ctx, check.Command[0], check.Command[1:]...)
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	cmd.Cancel = func() error {
+		return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+	}
+	cmd.WaitDelay = time.Second
+	output := &cappedBuffer{max: maxNagiosOutput}
+	cmd.Stdout = output
+
+	start := time.Now()
+	err := cmd.Run()
+	result.DurationMs = durationMs(time.Since(start))
+
+	if ctx.Err() == context.DeadlineExceeded {
+		result.Message = "plugin timed out after " + time.Since(start).Round(100*time.Millisecond).String()
+		return result
+	}
+	var exitErr *exec.ExitError
+	switch {
+	case err == nil:
+		result.ExitCode = 0
+	case errors.As(err, &exitErr) && exitErr.ExitCode() >= 0:
+		result.ExitCode = exitErr.ExitCode()
+	default:
+		result.Message = err.Error()
+		return result
+	}
+	if result.ExitCode < len(nagiosStatuses) {
+		result.Status = nagiosStatuses[result.ExitCode]
+	}
+
+	result.Message, result.LongOutput, result.Perfdata = parseNagiosOutput(output.String())
+	return result
+}
+
+// parseNagiosOutput splits plugin output into the first line message, the
+// remaining long output and the perfdata. Perfdata follows a '|' on the
+// first line and, optionally, on one later line, continuing to the end.
+func parseNagiosOutput(output string) (string, string, []PerfdataPayload) {
+	first, rest, _ := strings.Cut(strings.TrimRight(output, "\n"), "\n")
+	message, perf, _ := strings.Cut(first, "|")
+	long, longPerf, found := strings.Cut(rest, "|")
+	if found {
+		perf += " " + longPerf
+	}
+	return strings.TrimSpace(message), strings.TrimSpace(long), parsePerfdata(perf)
+}
+
+// parsePerfdata parses items of the form 'label'=value[UOM];[warn];[crit];[min];[max].
+// Malformed items are skipped.
+func parsePerfdata(perf string) []PerfdataPayload {
+	var items []PerfdataPayload
+	for _, token := range splitPerfdata(perf) {
+		label, data, ok := cutPerfdataLabel(token)
+		if !ok {
+			continue
+		}
+		fields := strings.Split(data, ";")
+		item := PerfdataPayload{Label: label}
+		if fields[0] != "U" {
+			match := perfdataValuePattern.FindStringSubmatch(fields[0])
+			if match == nil {
+				continue
+			}
+			value, err := strconv.ParseFloat(match[1], 64)
+			if err != nil {
+				continue
+			}
+			item.Value = &value
+			item.Unit = match[2]
+		}
+		if len(fields) > 1 {
+			item.Warn = fields[1]
+		}
+		if len(fields) > 2 {
+			item.Crit = fields[2]
+		}
+		if len(fields) > 3 {
+			item.Min = parseOptionalFloat(fields[3])
+		}
+		if len(fields) > 4 {
+			item.Max = parseOptionalFloat(fields[4])
+		}
+		items = append(items, item)
+	}
+	return items
+}
+
+// splitPerfdata splits on whitespace outside single-quoted labels.
+func splitPerfdata(perf string) []string {
+	var tokens []string
+	var current strings.Builder
+	quoted := false
+	for _, r := range perf {
+		switch {
+		case r == '\'':
+			quoted = !quoted
+			current.WriteRune(r)
+		case !quoted && (r == ' ' || r == '\t' || r == '\n'):
+			if current.Len() > 0 {
+				tokens = append(tokens, current.String())
+				current.Reset()
+			}
+		default:
+			current.WriteRune(r)
+		}
+	}
+	if current.Len() > 0 {
+		tokens = append(tokens, current.String())
+	}
+	return tokens
+}
+
+// cutPerfdataLabel splits a token at the '=' after the label. Quoted labels
+// may contain '=' and escape a single quote by doubling it.
+func cutPerfdataLabel(token string) (string, string, bool) {
+	if strings.HasPrefix(token, "'") {
+		end := strings.LastIndex(token, "'=")
+		if end <= 0 {
+			return "", "", false
+		}
+		return strings.ReplaceAll(token[1:end], "''", "'"), token[end+2:], true
+	}
+	label, data, ok := strings.Cut(token, "=")
+	if !ok || label == "" {
+		return "", "", false
+	}
+	return label, data, true
+}
+
+func parseOptionalFloat(value string) *float64 {
+	parsed, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return nil
+	}
+	return &parsed
+}
+
+// cappedBuffer keeps the first max bytes written and silently drops the
+// rest, so a chatty command cannot exhaust memory.
+type cappedBuffer struct {
+	bytes.Buffer
+	max int
+}
+
+func (b *cappedBuffer) Write(p []byte) (int, error) {
+	if room := b.max - b.Len(); room > 0 {
+		b.Buffer.Write(p[:min(len(p), room)])
+	}
+	return len(p), nil
+}
@@ -0,0 +1,168 @@
+package metrics
+
+import (
+	"context"
+	"os/exec"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/MightyToolkit/mightymonitor-agent/internal/config"
+)
+
+func floatPtr(v float64) *float64 {
+	return &v
+}
+
+func TestParseNagiosOutput(t *testing.T) {
+	tests := []struct {
+		name        string
+		output      string
+		wantMessage string
+		wantLong    string
+		wantPerf    []PerfdataPayload
+	}{
+		{
+			name:        "message only",
+			output:      "OK - all good\n",
+			wantMessage: "OK - all good",
+		},
+		{
+			name:        "perfdata on the first line",
+			output:      "DISK OK - free space: / 3326 MB (56%); | /=2643MB;5948;5958;0;5968\n",
+			wantMessage: "DISK OK - free space: / 3326 MB (56%);",
+			wantPerf: []PerfdataPayload{
+				{Label: "/", Value: floatPtr(2643), Unit: "MB", Warn: "5948", Crit: "5958", Min: floatPtr(0), Max: floatPtr(5968)},
+			},
+		},
+		{
+			name: "long output and perfdata on later lines",
+			output: "DISK OK - free space: / 3326 MB (56%); | /=2643MB;5948;5958;0;5968\n" +
+				"/ 15272 MB (77%);\n" +
+				"/boot 68 MB (69%);\n" +
+				"/home 69357 MB (27%); | /boot=68MB;88;93;0;98\n" +
+				"/home=69357MB;253404;253409;0;253414\n",
+			wantMessage: "DISK OK - free space: / 3326 MB (56%);",
+			wantLong:    "/ 15272 MB (77%);\n/boot 68 MB (69%);\n/home 69357 MB (27%);",
+			wantPerf: []PerfdataPayload{
+				{Label: "/", Value: floatPtr(2643), Unit: "MB", Warn: "5948", Crit: "5958", Min: floatPtr(0), Max: floatPtr(5968)},
+				{Label: "/boot", Value: floatPtr(68), Unit: "MB", Warn: "88", Crit: "93", Min: floatPtr(0), Max: floatPtr(98)},
+				{Label: "/home", Value: floatPtr(69357), Unit: "MB", Warn: "253404", Crit: "253409", Min: floatPtr(0), Max: floatPtr(253414)},
+			},
+		},
+		{
+			name:        "long output without perfdata",
+			output:      "WARNING - 2 jobs late\nbackup: 3h late\nreport: 1h late\n",
+			wantMessage: "WARNING - 2 jobs late",
+			wantLong:    "backup: 3h late\nreport: 1h late",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			message, long, perf := parseNagiosOutput(tt.output)
+			if message != tt.wantMessage || long != tt.wantLong {
+				t.Errorf("parseNagiosOutput = %q, %q, want %q, %q", message, long, tt.wantMessage, tt.wantLong)
+			}
+			if !reflect.DeepEqual(perf, tt.wantPerf) {
+				t.Errorf("perfdata = %+v, want %+v", perf, tt.wantPerf)
+			}
+		})
+	}
+}
+
+func TestParsePerfdata(t *testing.T) {
+	tests := []struct {
+		name string
+		perf string
+		want []PerfdataPayload
+	}{
+		{
+			name: "value only",
+			perf: "load1=0.42",
+			want: []PerfdataPayload{{Label: "load1", Value: floatPtr(0.42)}},
+		},
+		{
+			name: "units and ranges",
+			perf: "time=0.012345s;1.0;2.0;0; size=1024B;;;0 used=95.5%;@10:20;~:90",
+			want: []PerfdataPayload{
+				{Label: "time", Value: floatPtr(0.012345), Unit: "s", Warn: "1.0", Crit: "2.0", Min: floatPtr(0)},
+				{Label: "size", Value: floatPtr(1024), Unit: "B", Min: floatPtr(0)},
+				{Label: "used", Value: floatPtr(95.5), Unit: "%", Warn: "@10:20", Crit: "~:90"},
+			},
+		},
+		{
+			name: "counter and negative values",
+			perf: "requests=12345c offset=-0.25s temp=-1.5e2",
+			want: []PerfdataPayload{
+				{Label: "requests", Value: floatPtr(12345), Unit: "c"},
+				{Label: "offset", Value: floatPtr(-0.25), Unit: "s"},
+				{Label: "temp", Value: floatPtr(-150)},
+			},
+		},
+		{
+			name: "quoted labels",
+			perf: `'disk usage /var'=80%;90;95 'a=b'=1 'it''s'=2`,
+			want: []PerfdataPayload{
+				{Label: "disk usage /var", Value: floatPtr(80), Unit: "%", Warn: "90", Crit: "95"},
+				{Label: "a=b", Value: floatPtr(1)},
+				{Label: "it's", Value: floatPtr(2)},
+			},
+		},
+		{
+			name: "undetermined value",
+			perf: "rtt=U;100;200;0;",
+			want: []PerfdataPayload{{Label: "rtt", Warn: "100", Crit: "200", Min: floatPtr(0)}},
+		},
+		{
+			name: "separated by tabs and repeated spaces",
+			perf: "  a=1\tb=2   c=3 ",
+			want: []PerfdataPayload{
+				{Label: "a", Value: floatPtr(1)},
+				{Label: "b", Value: floatPtr(2)},
+				{Label: "c", Value: floatPtr(3)},
+			},
+		},
+		{
+			// An unterminated quote takes the rest of the line with it.
+			name: "malformed items are skipped",
+			perf: "noequals =5 empty= bad=abc ok=7 twounits=1MB2 'unterminated=1 x=2",
+			want: []PerfdataPayload{{Label: "ok", Value: floatPtr(7)}},
+		},
+		{
+			name: "empty",
+			perf: "",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := parsePerfdata(tt.perf); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parsePerfdata(%q) = %+v, want %+v", tt.perf, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRunNagiosCheckDeadline(t *testing.T) {
+	if _, err := exec.LookPath("sleep"); err != nil {
+		t.Skip("sleep not available")
+	}
+	// The overall deadline applies even when the check allows much longer.
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	result := runNagiosCheck(ctx, config.NagiosCheck{Name: "slow", Command: []string{"sleep", "30"}, TimeoutSeconds: 60})
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Fatalf("runNagiosCheck took %s, want it stopped at the deadline", elapsed)
+	}
+	if result.Status != "UNKNOWN" || result.ExitCode != 3 || !strings.HasPrefix(result.Message, "plugin timed out after ") {
+		t.Errorf("result = %+v, want UNKNOWN timeout", result)
+	}
+
+	result = runNagiosCheck(context.Background(), config.NagiosCheck{Command: []string{"sh", "-c", "echo 'WARNING - late | age=90s;60;120'; exit 1"}})
+	if result.Name != "sh" || result.Status != "WARNING" || result.ExitCode != 1 || result.Message != "WARNING - late" || len(result.Perfdata) != 1 {
+		t.Errorf("result = %+v, want WARNING with one perfdata item", result)
+	}
+}
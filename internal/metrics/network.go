@@ -2,7 +2,6 @@ package metrics
 
 import (
 	"bufio"
-	"encoding/json"
 	"fmt"
 	"os"
 	"path/filepath"
@@ -14,16 +13,22 @@ import (
 type NetworkMetrics struct {
 	RxBytesPerSec float64
 	TxBytesPerSec float64
+	Softnet       *SoftnetMetrics
+	Interfaces    []InterfaceDropMetrics
 }
 
 type netState struct {
-	RxBytes   uint64 `json:"rxBytes"`
-	TxBytes   uint64 `json:"txBytes"`
-	Timestamp int64  `json:"timestamp"`
+	RxBytes    uint64                       `json:"rxBytes"`
+	TxBytes    uint64                       `json:"txBytes"`
+	Timestamp  int64                        `json:"timestamp"`
+	Softnet    []softnetCounters            `json:"softnet,omitempty"`
+	Interfaces map[string]ifaceDropCounters `json:"interfaces,omitempty"`
 }
 
 // CollectNetwork reads /proc/net/dev, sums rx/tx bytes for all non-loopback
 // interfaces, computes bytes/sec from the previous state, and persists state.
+// Softnet backlog counters and NIC drop counters are tracked alongside and
+// reported as rates when both samples have them.
 // Returns nil on first run, counter reset, or elapsed > 300s.
 func CollectNetwork(stateDir string) (*NetworkMetrics, error) {
 	rx, tx, err := readProcNetDev()
@@ -31,12 +36,22 @@ func CollectNetwork(stateDir string) (*NetworkMetrics, error) {
 		return nil, fmt.Errorf("read /proc/net/dev: %w", err)
 	}
 
+	// Drop counters are best-effort; byte rates are still reported without them.
+	softnet, _ := readSoftnetStat()
+	drops, _ := readInterfaceDrops()
+
 	now := time.Now().Unix()
 	stateFile := filepath.Join(stateDir, "net_state.json")
 
 	prev, err := loadNetState(stateFile)
 
-	current := netState{RxBytes: rx, TxBytes: tx, Timestamp: now}
+	current := netState{
+		RxBytes:    rx,
+		TxBytes:    tx,
+		Timestamp:  now,
+		Softnet:    softnet,
+		Interfaces: drops,
+	}
 	if saveErr := saveNetState(stateFile, current); saveErr != nil {
 		return nil, fmt.Errorf("save net state: %w", saveErr)
 	}
@@ -59,6 +74,8 @@ func CollectNetwork(stateDir string) (*NetworkMetrics, error) {
 	return &NetworkMetrics{
 		RxBytesPerSec: float64(rx-prev.RxBytes) / elapsed,
 		TxBytesPerSec: float64(tx-prev.TxBytes) / elapsed,
+		Softnet:       softnetRates(prev.Softnet, softnet, elapsed),
+		Interfaces:    interfaceDropRates(prev.Interfaces, drops, elapsed),
 	}, nil
 }
 
@@ -104,25 +121,13 @@ func readProcNetDev() (rxTotal uint64, txTotal uint64, err error) {
 }
 
 func loadNetState(path string) (*netState, error) {
-	data, err := os.ReadFile(path)
-	if err != nil {
-		return nil, err
-	}
 	var state netState
-	if err := json.Unmarshal(data, &state); err != nil {
+	if err := loadState(path, &state); err != nil {
 		return nil, err
 	}
 	return &state, nil
 }
 
 func saveNetState(path string, state netState) error {
-	data, err := json.Marshal(state)
-	if err != nil {
-		return err
-	}
-	tmp := path + ".tmp"
-	if err := os.WriteFile(tmp, data, 0o600); err != nil {
-		return err
-	}
-	return os.Rename(tmp, path)
+	return saveState(path, state)
 }
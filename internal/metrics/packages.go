@@ -0,0 +1,227 @@
+package metrics
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+const (
+	dpkgStatusPath   = "/var/lib/dpkg/status"
+	apkInstalledPath = "/lib/apk/db/installed"
+)
+
+type packagesState struct {
+	Manager  string            `json:"manager"`
+	Hash     string            `json:"hash"`
+	Packages map[string]string `json:"packages"`
+}
+
+// CollectPackageChanges reads the installed package database and compares it
+// with the copy last committed to the state directory. The first call returns
+// the full inventory; later calls return only installed, removed and upgraded
+// packages, or nil when nothing changed. The new copy is staged in pending.
+// Returns nil, nil on hosts without a supported package manager.
+func CollectPackageChanges(stateDir string, pending *PendingState) (*PackagesPayload, error) {
+	manager, packages, err := readInstalledPackages()
+	if err != nil {
+		return nil, err
+	}
+	if manager == "" {
+		return nil, nil
+	}
+
+	current := packagesState{
+		Manager:  manager,
+		Hash:     packagesHash(packages),
+		Packages: make(map[string]string, len(packages)),
+	}
+	for _, pkg := range packages {
+		current.Packages[packageKey(pkg)] = pkg.Version
+	}
+
+	stateFile := filepath.Join(stateDir, "packages_state.json")
+	var prev packagesState
+	prevErr := loadState(stateFile, &prev)
+	if prevErr == nil && prev.Manager == manager && prev.Hash == current.Hash {
+		return nil, nil
+	}
+	pending.stage(stateFile, current)
+
+	result := &PackagesPayload{
+		Manager: manager,
+		Hash:    current.Hash,
+		Count:   len(packages),
+	}
+	if prevErr != nil || prev.Manager != manager || prev.Packages == nil {
+		result.Full = true
+		result.Packages = packages
+		return result, nil
+	}
+
+	for _, pkg := range packages {
+		oldVersion, ok := prev.Packages[packageKey(pkg)]
+		switch {
+		case !ok:
+			result.Installed = append(result.Installed, pkg)
+		case oldVersion != pkg.Version:
+			result.Upgraded = append(result.Upgraded, PackageChangePayload{
+				Name:       pkg.Name,
+				Arch:       pkg.Arch,
+				OldVersion: oldVersion,
+				NewVersion: pkg.Version,
+			})
+		}
+	}
+
+	removedKeys := make([]string, 0)
+	for key := range prev.Packages {
+		if _, ok := current.Packages[key]; !ok {
+			removedKeys = append(removedKeys, key)
+		}
+	}
+	sort.Strings(removedKeys)
+	for _, key := range removedKeys {
+		name, arch, _ := strings.Cut(key, ":")
+		result.Removed = append(result.Removed, PackagePayload{Name: name, Version: prev.Packages[key], Arch: arch})
+	}
+	return result, nil
+}
+
+// readInstalledPackages returns the package manager name and its installed
+// packages sorted by name, or "" when no supported database exists.
+func readInstalledPackages() (string, []PackagePayload, error) {
+	sources := []struct {
+		manager string
+		path    string
+		parse   func(io.Reader) ([]PackagePayload, error)
+	}{
+		{"dpkg", dpkgStatusPath, parseDpkgStatus},
+		{"apk", apkInstalledPath, parseApkInstalled},
+	}
+
+	for _, source := range sources {
+		f, err := os.Open(source.path)
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return "", nil, err
+		}
+		packages, err := source.parse(f)
+		f.Close()
+		if err != nil {
+			return "", nil, fmt.Errorf("parse %s: %w", source.path, err)
+		}
+
+		sort.Slice(packages, func(i, j int) bool {
+			if packages[i].Name != packages[j].Name {
+				return packages[i].Name < packages[j].Name
+			}
+			return packages[i].Arch < packages[j].Arch
+		})
+		return source.manager, packages, nil
+	}
+	return "", nil, nil
+}
+
+// parseDpkgStatus parses the RFC 822 style stanzas of /var/lib/dpkg/status
+// and keeps packages whose status is "installed".
+func parseDpkgStatus(r io.Reader) ([]PackagePayload, error) {
+	var packages []PackagePayload
+	var current PackagePayload
+	installed := false
+
+	flush := func() {
+		if current.Name != "" && installed {
+			packages = append(packages, current)
+		}
+		current = PackagePayload{}
+		installed = false
+	}
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" {
+			flush()
+			continue
+		}
+		if line[0] == ' ' || line[0] == '\t' {
+			continue
+		}
+		key, value, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		value = strings.TrimSpace(value)
+		switch key {
+		case "Package":
+			current.Name = value
+		case "Version":
+			current.Version = value
+		case "Architecture":
+			current.Arch = value
+		case "Status":
+			installed = strings.HasSuffix(value, " installed")
+		}
+	}
+	flush()
+	return packages, scanner.Err()
+}
+
+// parseApkInstalled parses apk's installed database, where each package is a
+// block of single-letter "X:value" lines.
+func parseApkInstalled(r io.Reader) ([]PackagePayload, error) {
+	var packages []PackagePayload
+	var current PackagePayload
+
+	flush := func() {
+		if current.Name != "" {
+			packages = append(packages, current)
+		}
+		current = PackagePayload{}
+	}
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" {
+			flush()
+			continue
+		}
+		if len(line) < 2 || line[1] != ':' {
+			continue
+		}
+		switch line[0] {
+		case 'P':
+			current.Name = line[2:]
+		case 'V':
+			current.Version = line[2:]
+		case 'A':
+			current.Arch = line[2:]
+		}
+	}
+	flush()
+	return packages, scanner.Err()
+}
+
+func packageKey(pkg PackagePayload) string {
+	return pkg.Name + ":" + pkg.Arch
+}
+
+func packagesHash(packages []PackagePayload) string {
+	h := sha256.New()
+	for _, pkg := range packages {
+		fmt.Fprintf(h, "%s\t%s\t%s\n", pkg.Name, pkg.Arch, pkg.Version)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
@@ -1,15 +1,97 @@
 package metrics
 
 type Payload struct {
-	HostID        string          `json:"hostId"`
-	Hostname      string          `json:"hostname"`
-	AgentVersion  string          `json:"agentVersion"`
-	TS            int64           `json:"ts"`
-	CPU           CPUPayload      `json:"cpu"`
-	Memory        MemoryPayload   `json:"memory"`
-	Disk          DiskPayload     `json:"disk"`
-	Network       *NetworkPayload `json:"network,omitempty"`
-	UptimeSeconds *int64          `json:"uptimeSeconds,omitempty"`
+	HostID        string                      `json:"hostId"`
+	Hostname      string                      `json:"hostname"`
+	AgentVersion  string                      `json:"agentVersion"`
+	TS            int64                       `json:"ts"`
+	CPU           CPUPayload                  `json:"cpu"`
+	Memory        MemoryPayload               `json:"memory"`
+	Disk          DiskPayload                 `json:"disk"`
+	Network       *NetworkPayload             `json:"network,omitempty"`
+	UptimeSeconds *int64                      `json:"uptimeSeconds,omitempty"`
+	Clock         *ClockPayload               `json:"clock,omitempty"`
+	Reboot        *RebootPayload              `json:"reboot,omitempty"`
+	Sessions      []SessionPayload            `json:"sessions,omitempty"`
+	AuthFailures  *AuthFailuresPayload        `json:"authFailures,omitempty"`
+	Posture       *PosturePayload             `json:"posture,omitempty"`
+	Sysctl        *SysctlPayload              `json:"sysctl,omitempty"`
+	Containers    []ContainerResourcesPayload `json:"containers,omitempty"`
+	Services      []ServiceResourcesPayload   `json:"services,omitempty"`
+	Docker        []DockerContainerPayload    `json:"docker,omitempty"`
+	Systemd       *SystemdPayload             `json:"systemd,omitempty"`
+	HTTPChecks    []HTTPCheckPayload          `json:"httpChecks,omitempty"`
+	TLSCerts      []TLSCertificatePayload     `json:"tlsCertificates,omitempty"`
+	PortChecks    []PortCheckPayload          `json:"portChecks,omitempty"`
+	DNSChecks     []DNSCheckPayload           `json:"dnsChecks,omitempty"`
+	NagiosChecks  []NagiosCheckPayload        `json:"nagiosChecks,omitempty"`
+	LogMonitors   []LogMonitorPayload         `json:"logMonitors,omitempty"`
+	AccessLogs    []AccessLogPayload          `json:"accessLogs,omitempty"`
+	FileChecks    []FileCheckPayload          `json:"fileChecks,omitempty"`
+	Inventory     *InventoryPayload           `json:"inventory,omitempty"`
+	Packages      *PackagesPayload            `json:"packages,omitempty"`
+	Events        []EventPayload              `json:"events,omitempty"`
+}
+
+// PackagesPayload carries the full package list on first upload (Full) and
+// only the differences against the previous upload afterwards.
+type PackagesPayload struct {
+	Manager   string                 `json:"manager"`
+	Hash      string                 `json:"hash"`
+	Count     int                    `json:"count"`
+	Full      bool                   `json:"full"`
+	Packages  []PackagePayload       `json:"packages,omitempty"`
+	Installed []PackagePayload       `json:"installed,omitempty"`
+	Removed   []PackagePayload       `json:"removed,omitempty"`
+	Upgraded  []PackageChangePayload `json:"upgraded,omitempty"`
+}
+
+type PackagePayload struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+	Arch    string `json:"arch,omitempty"`
+}
+
+type PackageChangePayload struct {
+	Name       string `json:"name"`
+	Arch       string `json:"arch,omitempty"`
+	OldVersion string `json:"oldVersion"`
+	NewVersion string `json:"newVersion"`
+}
+
+// EventPayload describes a discrete state change observed by the agent, as
+// opposed to the point-in-time metrics in the rest of the payload.
+type EventPayload struct {
+	Type    string         `json:"type"`
+	TS      int64          `json:"ts"`
+	Message string         `json:"message"`
+	Details map[string]any `json:"details,omitempty"`
+}
+
+type InventoryPayload struct {
+	OSID           string        `json:"osId,omitempty"`
+	OSName         string        `json:"osName,omitempty"`
+	OSVersion      string        `json:"osVersion,omitempty"`
+	OSPrettyName   string        `json:"osPrettyName,omitempty"`
+	KernelRelease  string        `json:"kernelRelease,omitempty"`
+	Architecture   string        `json:"architecture,omitempty"`
+	BootID         string        `json:"bootId,omitempty"`
+	MachineID      string        `json:"machineId,omitempty"`
+	Virtualization string        `json:"virtualization,omitempty"`
+	Container      string        `json:"container,omitempty"`
+	Timezone       string        `json:"timezone,omitempty"`
+	Cloud          *CloudPayload `json:"cloud,omitempty"`
+	Hash           string        `json:"hash"`
+}
+
+type CloudPayload struct {
+	Provider     string `json:"provider"`
+	InstanceID   string `json:"instanceId,omitempty"`
+	InstanceType string `json:"instanceType,omitempty"`
+	Region       string `json:"region,omitempty"`
+	Zone         string `json:"zone,omitempty"`
+	Image        string `json:"image,omitempty"`
+	PublicIP     string `json:"publicIp,omitempty"`
 }
 
 type CPUPayload struct {
@@ -30,6 +112,320 @@ type DiskPayload struct {
 	FreeBytes  int64 `json:"freeBytes"`
 }
 
+type ClockPayload struct {
+	Synchronized   bool    `json:"synchronized"`
+	State          string  `json:"state"`
+	OffsetMicros   float64 `json:"offsetMicros"`
+	FrequencyPPM   float64 `json:"frequencyPpm"`
+	EstErrorMicros int64   `json:"estErrorMicros"`
+	MaxErrorMicros int64   `json:"maxErrorMicros"`
+}
+
+type RebootPayload struct {
+	Required          bool     `json:"required"`
+	Reasons           []string `json:"reasons,omitempty"`
+	Packages          []string `json:"packages,omitempty"`
+	RunningKernel     string   `json:"runningKernel,omitempty"`
+	LatestKernel      string   `json:"latestKernel,omitempty"`
+	DeletedLibraries  []string `json:"deletedLibraries,omitempty"`
+	AffectedProcesses int      `json:"affectedProcesses,omitempty"`
+}
+
+type SessionPayload struct {
+	User      string `json:"user"`
+	TTY       string `json:"tty"`
+	Host      string `json:"host,omitempty"`
+	LoginTime int64  `json:"loginTime"`
+}
+
+// AuthFailuresPayload counts failed logins since the previous send.
+type AuthFailuresPayload struct {
+	FailedSSHLogins int                 `json:"failedSshLogins"`
+	BtmpFailures    int                 `json:"btmpFailures"`
+	TopSources      []AuthSourcePayload `json:"topSources,omitempty"`
+}
+
+type AuthSourcePayload struct {
+	Address string `json:"address"`
+	Count   int    `json:"count"`
+}
+
+type PosturePayload struct {
+	Score  float64               `json:"score"`
+	Passed int                   `json:"passed"`
+	Failed int                   `json:"failed"`
+	Checks []PostureCheckPayload `json:"checks"`
+}
+
+type PostureCheckPayload struct {
+	ID          string `json:"id"`
+	Description string `json:"description,omitempty"`
+	Status      string `json:"status"`
+	Detail      string `json:"detail,omitempty"`
+}
+
+type SysctlPayload struct {
+	Checked int                  `json:"checked"`
+	Drift   []SysctlDriftPayload `json:"drift,omitempty"`
+}
+
+type SysctlDriftPayload struct {
+	Key      string `json:"key"`
+	Expected string `json:"expected"`
+	Actual   string `json:"actual"`
+}
+
+type ContainerResourcesPayload struct {
+	ID         string `json:"id"`
+	Runtime    string `json:"runtime"`
+	PodUID     string `json:"podUid,omitempty"`
+	CgroupPath string `json:"cgroupPath"`
+	CgroupResourcesPayload
+}
+
+type DockerContainerPayload struct {
+	ID           string `json:"id"`
+	Name         string `json:"name"`
+	Image        string `json:"image"`
+	State        string `json:"state"`
+	Status       string `json:"status,omitempty"`
+	Health       string `json:"health,omitempty"`
+	RestartCount int    `json:"restartCount"`
+	ExitCode     int    `json:"exitCode"`
+	OOMKilled    bool   `json:"oomKilled,omitempty"`
+	StartedAt    string `json:"startedAt,omitempty"`
+	FinishedAt   string `json:"finishedAt,omitempty"`
+}
+
+// SystemdPayload lists at most 100 failed and restarting units; the counts
+// are always complete.
+type SystemdPayload struct {
+	FailedCount     int                          `json:"failedCount"`
+	RestartingCount int                          `json:"restartingCount"`
+	Failed          []SystemdUnitPayload         `json:"failed,omitempty"`
+	Restarting      []SystemdUnitPayload         `json:"restarting,omitempty"`
+	Critical        []SystemdCriticalUnitPayload `json:"critical,omitempty"`
+}
+
+type SystemdUnitPayload struct {
+	Name        string  `json:"name"`
+	Description string  `json:"description,omitempty"`
+	LoadState   string  `json:"loadState"`
+	ActiveState string  `json:"activeState"`
+	SubState    string  `json:"subState"`
+	NRestarts   *uint32 `json:"nRestarts,omitempty"`
+}
+
+// SystemdCriticalUnitPayload is the state of a configured critical unit.
+// ExitCode is "exited", "killed" or "dumped"; ExitStatus is the exit code or
+// the signal number accordingly.
+type SystemdCriticalUnitPayload struct {
+	Name        string  `json:"name"`
+	OK          bool    `json:"ok"`
+	LoadState   string  `json:"loadState,omitempty"`
+	ActiveState string  `json:"activeState,omitempty"`
+	SubState    string  `json:"subState,omitempty"`
+	NRestarts   *uint32 `json:"nRestarts,omitempty"`
+	Result      string  `json:"result,omitempty"`
+	ExitCode    string  `json:"exitCode,omitempty"`
+	ExitStatus  *int32  `json:"exitStatus,omitempty"`
+	Error       string  `json:"error,omitempty"`
+}
+
+// HTTPCheckPayload is the outcome of one synthetic HTTP check. Phase
+// durations are in milliseconds and omitted when the phase did not happen,
+// e.g. DNS for IP literals or TLS for plain HTTP.
+type HTTPCheckPayload struct {
+	Name          string   `json:"name"`
+	URL           string   `json:"url"`
+	OK            bool     `json:"ok"`
+	StatusCode    int      `json:"statusCode,omitempty"`
+	BodyMatched   *bool    `json:"bodyMatched,omitempty"`
+	ResponseBytes int64    `json:"responseBytes"`
+	TotalMs       float64  `json:"totalMs"`
+	DNSMs         *float64 `json:"dnsMs,omitempty"`
+	ConnectMs     *float64 `json:"connectMs,omitempty"`
+	TLSMs         *float64 `json:"tlsMs,omitempty"`
+	TTFBMs        *float64 `json:"ttfbMs,omitempty"`
+	Error         string   `json:"error,omitempty"`
+}
+
+// TLSCertificatePayload describes the leaf certificate of an endpoint or PEM
+// file. DaysUntilExpiry is negative once the certificate has expired.
+// ChainValid reports whether the chain verifies against the system roots
+// and, for endpoints, the server name.
+type TLSCertificatePayload struct {
+	Source          string   `json:"source"`
+	Target          string   `json:"target"`
+	ServerName      string   `json:"serverName,omitempty"`
+	Subject         string   `json:"subject,omitempty"`
+	Issuer          string   `json:"issuer,omitempty"`
+	SANs            []string `json:"sans,omitempty"`
+	SerialNumber    string   `json:"serialNumber,omitempty"`
+	NotBefore       int64    `json:"notBefore,omitempty"`
+	NotAfter        int64    `json:"notAfter,omitempty"`
+	DaysUntilExpiry *float64 `json:"daysUntilExpiry,omitempty"`
+	ChainValid      bool     `json:"chainValid"`
+	ChainError      string   `json:"chainError,omitempty"`
+	Error           string   `json:"error,omitempty"`
+}
+
+// PortCheckPayload is the outcome of one port check. Banner holds the start
+// of the response when ExpectPattern was configured.
+type PortCheckPayload struct {
+	Name          string   `json:"name"`
+	Protocol      string   `json:"protocol"`
+	Address       string   `json:"address"`
+	Up            bool     `json:"up"`
+	ConnectMs     *float64 `json:"connectMs,omitempty"`
+	ResponseMs    *float64 `json:"responseMs,omitempty"`
+	Banner        string   `json:"banner,omitempty"`
+	BannerMatched *bool    `json:"bannerMatched,omitempty"`
+	Error         string   `json:"error,omitempty"`
+}
+
+// DNSCheckPayload is the outcome of one DNS check. Answers are the records
+// of the queried type in presentation form, e.g. "10 mx.example.com" for MX.
+type DNSCheckPayload struct {
+	Name      string   `json:"name"`
+	Query     string   `json:"query"`
+	Type      string   `json:"type"`
+	Resolver  string   `json:"resolver"`
+	OK        bool     `json:"ok"`
+	Rcode     string   `json:"rcode,omitempty"`
+	LatencyMs *float64 `json:"latencyMs,omitempty"`
+	Answers   []string `json:"answers,omitempty"`
+	Matched   *bool    `json:"matched,omitempty"`
+	Error     string   `json:"error,omitempty"`
+}
+
+// NagiosCheckPayload is the result of one plugin run. Status is OK, WARNING,
+// CRITICAL or UNKNOWN; timeouts and failures to start are UNKNOWN.
+type NagiosCheckPayload struct {
+	Name       string            `json:"name"`
+	Status     string            `json:"status"`
+	ExitCode   int               `json:"exitCode"`
+	Message    string            `json:"message"`
+	LongOutput string            `json:"longOutput,omitempty"`
+	DurationMs float64           `json:"durationMs"`
+	Perfdata   []PerfdataPayload `json:"perfdata,omitempty"`
+}
+
+// PerfdataPayload is one perfdata item. Value is omitted when the plugin
+// reported "U"; Warn and Crit keep the Nagios range syntax.
+type PerfdataPayload struct {
+	Label string   `json:"label"`
+	Value *float64 `json:"value,omitempty"`
+	Unit  string   `json:"unit,omitempty"`
+	Warn  string   `json:"warn,omitempty"`
+	Crit  string   `json:"crit,omitempty"`
+	Min   *float64 `json:"min,omitempty"`
+	Max   *float64 `json:"max,omitempty"`
+}
+
+// LogMonitorPayload holds the rule matches among the lines appended to Path
+// during the last WindowSeconds. WindowSeconds is omitted on the first run,
+// which only records the end of the file. Partial means the per-run read
+// limit was hit, so the matches cover only part of the window and
+// WindowSeconds is omitted as well.
+type LogMonitorPayload struct {
+	Path          string           `json:"path"`
+	WindowSeconds *int64           `json:"windowSeconds,omitempty"`
+	Partial       bool             `json:"partial,omitempty"`
+	LinesRead     int              `json:"linesRead"`
+	Rules         []LogRulePayload `json:"rules,omitempty"`
+	Error         string           `json:"error,omitempty"`
+}
+
+type LogRulePayload struct {
+	Name     string   `json:"name"`
+	Severity string   `json:"severity,omitempty"`
+	Matches  int      `json:"matches"`
+	Samples  []string `json:"samples,omitempty"`
+	Error    string   `json:"error,omitempty"`
+}
+
+// AccessLogPayload summarises the requests logged during the last
+// WindowSeconds. Timings are only present when the log format includes them.
+// Partial means the per-run read limit was hit: the counts cover only part of
+// the window and WindowSeconds is the span of the requests read, or absent
+// with the rate when the format has no timestamps.
+type AccessLogPayload struct {
+	Path           string                      `json:"path"`
+	WindowSeconds  *int64                      `json:"windowSeconds,omitempty"`
+	Partial        bool                        `json:"partial,omitempty"`
+	Requests       int                         `json:"requests"`
+	RequestsPerSec *float64                    `json:"requestsPerSec,omitempty"`
+	Unparsed       int                         `json:"unparsed"`
+	StatusClasses  AccessLogStatusPayload      `json:"statusClasses"`
+	RequestTimeMs  *PercentilesPayload         `json:"requestTimeMs,omitempty"`
+	UpstreamTimeMs *PercentilesPayload         `json:"upstreamTimeMs,omitempty"`
+	Top5xxPaths    []AccessLogPathCountPayload `json:"top5xxPaths,omitempty"`
+	Error          string                      `json:"error,omitempty"`
+}
+
+type AccessLogStatusPayload struct {
+	Status1xx int `json:"1xx"`
+	Status2xx int `json:"2xx"`
+	Status3xx int `json:"3xx"`
+	Status4xx int `json:"4xx"`
+	Status5xx int `json:"5xx"`
+}
+
+type PercentilesPayload struct {
+	P50 float64 `json:"p50"`
+	P95 float64 `json:"p95"`
+	P99 float64 `json:"p99"`
+}
+
+type AccessLogPathCountPayload struct {
+	Path  string `json:"path"`
+	Count int    `json:"count"`
+}
+
+// FileCheckPayload is the outcome of one file check. Failures lists every
+// limit that was exceeded.
+type FileCheckPayload struct {
+	Name       string   `json:"name"`
+	Path       string   `json:"path"`
+	OK         bool     `json:"ok"`
+	AgeSeconds *int64   `json:"ageSeconds,omitempty"`
+	SizeBytes  *int64   `json:"sizeBytes,omitempty"`
+	Count      *int     `json:"count,omitempty"`
+	NewestFile string   `json:"newestFile,omitempty"`
+	Failures   []string `json:"failures,omitempty"`
+	Error      string   `json:"error,omitempty"`
+}
+
+type ServiceResourcesPayload struct {
+	Unit string `json:"unit"`
+	CgroupResourcesPayload
+}
+
+// CgroupResourcesPayload is the resource usage of one cgroup. Rates and
+// NewOOMKills are relative to the previous sample and omitted on first sight.
+type CgroupResourcesPayload struct {
+	CPUPercent         *float64         `json:"cpuPercent,omitempty"`
+	MemoryCurrentBytes int64            `json:"memoryCurrentBytes"`
+	MemoryMaxBytes     *int64           `json:"memoryMaxBytes,omitempty"`
+	Tasks              *int64           `json:"tasks,omitempty"`
+	OOMEvents          uint64           `json:"oomEvents"`
+	OOMKills           uint64           `json:"oomKills"`
+	NewOOMKills        *uint64          `json:"newOomKills,omitempty"`
+	IOReadBytesPerSec  *float64         `json:"ioReadBytesPerSec,omitempty"`
+	IOWriteBytesPerSec *float64         `json:"ioWriteBytesPerSec,omitempty"`
+	Pressure           *PressurePayload `json:"pressure,omitempty"`
+}
+
+// PressurePayload holds PSI avg60 values in percent.
+type PressurePayload struct {
+	CPUSome    *float64 `json:"cpuSome,omitempty"`
+	MemorySome *float64 `json:"memorySome,omitempty"`
+	MemoryFull *float64 `json:"memoryFull,omitempty"`
+	IOSome     *float64 `json:"ioSome,omitempty"`
+	IOFull     *float64 `json:"ioFull,omitempty"`
+}
+
 // Internal collector metrics
 
 type CPUMetrics struct {
@@ -50,7 +446,49 @@ type DiskMetrics struct {
 	FreeBytes  int64
 }
 
+type RebootRequiredMetrics struct {
+	Required          bool
+	Reasons           []string
+	Packages          []string
+	RunningKernel     string
+	LatestKernel      string
+	DeletedLibraries  []string
+	AffectedProcesses int
+}
+
+type ClockMetrics struct {
+	Synchronized   bool
+	State          string
+	OffsetMicros   float64
+	FrequencyPPM   float64
+	EstErrorMicros int64
+	MaxErrorMicros int64
+}
+
 type NetworkPayload struct {
-	RxBytesPerSec float64 `json:"rxBytesPerSec"`
-	TxBytesPerSec float64 `json:"txBytesPerSec"`
+	RxBytesPerSec float64                 `json:"rxBytesPerSec"`
+	TxBytesPerSec float64                 `json:"txBytesPerSec"`
+	Softnet       *SoftnetPayload         `json:"softnet,omitempty"`
+	Interfaces    []InterfaceDropsPayload `json:"interfaces,omitempty"`
+}
+
+type SoftnetPayload struct {
+	ProcessedPerSec   float64             `json:"processedPerSec"`
+	DroppedPerSec     float64             `json:"droppedPerSec"`
+	TimeSqueezePerSec float64             `json:"timeSqueezePerSec"`
+	CPUs              []SoftnetCPUPayload `json:"cpus,omitempty"`
+}
+
+type SoftnetCPUPayload struct {
+	CPU               int     `json:"cpu"`
+	ProcessedPerSec   float64 `json:"processedPerSec"`
+	DroppedPerSec     float64 `json:"droppedPerSec"`
+	TimeSqueezePerSec float64 `json:"timeSqueezePerSec"`
+}
+
+type InterfaceDropsPayload struct {
+	Name               string  `json:"name"`
+	RxMissedPerSec     float64 `json:"rxMissedPerSec"`
+	RxFifoErrorsPerSec float64 `json:"rxFifoErrorsPerSec"`
+	TxFifoErrorsPerSec float64 `json:"txFifoErrorsPerSec"`
 }
@@ -0,0 +1,148 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/MightyToolkit/mightymonitor-agent/internal/config"
+)
+
+const (
+	maxPortCheckResponse = 4096
+	maxReportedBanner    = 256
+
+	// udpUnreachableWait is how long a UDP check without ExpectPattern waits
+	// for an ICMP port unreachable before declaring the port up.
+	udpUnreachableWait = 2 * time.Second
+)
+
+// CollectPortChecks runs the configured TCP and UDP checks concurrently.
+// Each check stops at its own timeout or when ctx is done.
+func CollectPortChecks(ctx context.Context, checks []config.PortCheck) []PortCheckPayload {
+	return runChecks(checks, func(check config.PortCheck) PortCheckPayload {
+		return runPortCheck(ctx, check)
+	})
+}
+
+func runPortCheck(ctx context.Context, check config.PortCheck) PortCheckPayload {
+	protocol := strings.ToLower(check.Protocol)
+	if protocol == "" {
+		protocol = "tcp"
+	}
+	result := PortCheckPayload{Name: check.Name, Protocol: protocol, Address: check.Address}
+	if result.Name == "" {
+		result.Name = protocol + "://" + check.Address
+	}
+	if protocol != "tcp" && protocol != "udp" {
+		result.Error = fmt.Sprintf("unsupported protocol %q", check.Protocol)
+		return result
+	}
+	if protocol == "udp" && check.Send == "" {
+		result.Error = "udp checks need send"
+		return result
+	}
+
+	var pattern *regexp.Regexp
+	if check.ExpectPattern != "" {
+		var err error
+		pattern, err = regexp.Compile(check.ExpectPattern)
+		if err != nil {
+			result.Error = fmt.Sprintf("invalid expect_pattern: %v", err)
+			return result
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, checkTimeout(check.TimeoutSeconds))
+	defer cancel()
+
+	var dialer net.Dialer
+	start := time.Now()
+	conn, err := dialer.DialContext(ctx, protocol, check.Address)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	defer conn.Close()
+	if protocol == "tcp" {
+		// A UDP dial only binds a local socket, so it says nothing about the
+		// target.
+		connectMs := durationMs(time.Since(start))
+		result.ConnectMs = &connectMs
+	}
+	deadline, _ := ctx.Deadline()
+	_ = conn.SetDeadline(deadline)
+
+	sent := time.Now()
+	if check.Send != "" {
+		if _, err := conn.Write([]byte(check.Send)); err != nil {
+			result.Error = fmt.Sprintf("send: %v", err)
+			return result
+		}
+	}
+
+	if pattern == nil {
+		if protocol == "udp" {
+			_ = conn.SetReadDeadline(time.Now().Add(min(time.Until(deadline), udpUnreachableWait)))
+			if _, err := conn.Read(make([]byte, maxPortCheckResponse)); err != nil && !errors.Is(err, os.ErrDeadlineExceeded) {
+				result.Error = err.Error()
+				return result
+			}
+		}
+		result.Up = true
+		return result
+	}
+
+	response, matched, err := readPortCheckResponse(conn, protocol, pattern)
+	result.Banner = portCheckBanner(response)
+	result.BannerMatched = &matched
+	if len(response) > 0 {
+		responseMs := durationMs(time.Since(sent))
+		result.ResponseMs = &responseMs
+	}
+	switch {
+	case matched:
+		result.Up = true
+	case err != nil && !errors.Is(err, os.ErrDeadlineExceeded) && len(response) == 0:
+		result.Error = err.Error()
+	case len(response) == 0:
+		result.Error = "no response"
+	default:
+		result.Error = "response did not match expect_pattern"
+	}
+	return result
+}
+
+// readPortCheckResponse reads until the response matches pattern, the peer
+// closes the connection, the deadline passes or the size limit is reached.
+// UDP responses are a single datagram.
+func readPortCheckResponse(conn net.Conn, protocol string, pattern *regexp.Regexp) ([]byte, bool, error) {
+	buf := make([]byte, maxPortCheckResponse)
+	n := 0
+	for n < len(buf) {
+		read, err := conn.Read(buf[n:])
+		n += read
+		if pattern.Match(buf[:n]) {
+			return buf[:n], true, nil
+		}
+		if err != nil {
+			return buf[:n], false, err
+		}
+		if protocol == "udp" {
+			break
+		}
+	}
+	return buf[:n], false, nil
+}
+
+func portCheckBanner(response []byte) string {
+	if len(response) > maxReportedBanner {
+		response = response[:maxReportedBanner]
+	}
+	return strings.TrimSpace(strings.ToValidUTF8(string(response), ""))
+}
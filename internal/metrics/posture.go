@@ -0,0 +1,287 @@
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/MightyToolkit/mightymonitor-agent/internal/config"
+)
+
+const (
+	sshdConfigPath = "/etc/ssh/sshd_config"
+
+	maxReportedWorldWritable = 20
+)
+
+// DefaultPostureChecks is the built-in hardening baseline. Checks from the
+// config with the same ID replace these.
+var DefaultPostureChecks = []config.PostureCheck{
+	{
+		ID:              "ssh_permit_root_login",
+		Description:     "sshd does not allow root login with a password",
+		Kind:            config.PostureKindSSHD,
+		Key:             "PermitRootLogin",
+		Expect:          []string{"no", "prohibit-password", "without-password", "forced-commands-only"},
+		Default:         "prohibit-password",
+		AppliesIfExists: sshdConfigPath,
+	},
+	{
+		ID:              "ssh_password_authentication",
+		Description:     "sshd password authentication is disabled",
+		Kind:            config.PostureKindSSHD,
+		Key:             "PasswordAuthentication",
+		Expect:          []string{"no"},
+		Default:         "yes",
+		AppliesIfExists: sshdConfigPath,
+	},
+	{
+		ID:          "path_not_world_writable",
+		Description: "no world-writable directories or files in PATH",
+		Kind:        config.PostureKindPathWritable,
+	},
+	{
+		ID:              "unattended_upgrades",
+		Description:     "unattended-upgrades is enabled",
+		Kind:            config.PostureKindFileRegex,
+		Path:            "/etc/apt/apt.conf.d/20auto-upgrades",
+		Pattern:         `^\s*APT::Periodic::Unattended-Upgrade\s+"1"`,
+		AppliesIfExists: "/etc/apt",
+	},
+	{
+		ID:          "kernel_aslr",
+		Description: "full address space layout randomization",
+		Kind:        config.PostureKindSysctl,
+		Key:         "kernel.randomize_va_space",
+		Expect:      []string{"2"},
+	},
+	{
+		ID:          "ip_forwarding_disabled",
+		Description: "IPv4 forwarding is disabled",
+		Kind:        config.PostureKindSysctl,
+		Key:         "net.ipv4.ip_forward",
+		Expect:      []string{"0"},
+	},
+}
+
+// defaultPathDirs are checked in addition to the agent's own PATH, which is
+// often minimal when run from cron or systemd.
+var defaultPathDirs = []string{"/usr/local/sbin", "/usr/local/bin", "/usr/sbin", "/usr/bin", "/sbin", "/bin"}
+
+// CollectPosture evaluates DefaultPostureChecks merged with custom checks
+// and returns per-check results and an overall score, the percentage of
+// applicable checks that passed.
+func CollectPosture(custom []config.PostureCheck) *PosturePayload {
+	checks := mergePostureChecks(DefaultPostureChecks, custom)
+	result := &PosturePayload{Checks: make([]PostureCheckPayload, 0, len(checks))}
+
+	for _, check := range checks {
+		status, detail := evaluatePostureCheck(check)
+		result.Checks = append(result.Checks, PostureCheckPayload{
+			ID:          check.ID,
+			Description: check.Description,
+			Status:      status,
+			Detail:      detail,
+		})
+		switch status {
+		case "pass":
+			result.Passed++
+		case "fail":
+			result.Failed++
+		}
+	}
+	if total := result.Passed + result.Failed; total > 0 {
+		result.Score = float64(result.Passed) * 100 / float64(total)
+	}
+	return result
+}
+
+func mergePostureChecks(defaults []config.PostureCheck, custom []config.PostureCheck) []config.PostureCheck {
+	overrides := make(map[string]config.PostureCheck, len(custom))
+	for _, check := range custom {
+		overrides[check.ID] = check
+	}
+
+	merged := make([]config.PostureCheck, 0, len(defaults)+len(custom))
+	for _, check := range defaults {
+		if override, ok := overrides[check.ID]; ok {
+			check = override
+			delete(overrides, check.ID)
+		}
+		merged = append(merged, check)
+	}
+	for _, check := range custom {
+		if _, ok := overrides[check.ID]; ok {
+			merged = append(merged, check)
+		}
+	}
+	return merged
+}
+
+// evaluatePostureCheck returns "pass", "fail", "skip" when the check does not
+// apply to this host, or "error" when it could not be evaluated.
+func evaluatePostureCheck(check config.PostureCheck) (string, string) {
+	if check.Disabled {
+		return "skip", "disabled"
+	}
+	if check.AppliesIfExists != "" {
+		if _, err := os.Stat(check.AppliesIfExists); err != nil {
+			return "skip", check.AppliesIfExists + " not present"
+		}
+	}
+
+	switch check.Kind {
+	case config.PostureKindSSHD:
+		path := check.Path
+		if path == "" {
+			path = sshdConfigPath
+		}
+		value, found, err := sshdConfigValue(path, check.Key)
+		if err != nil {
+			return "error", err.Error()
+		}
+		if !found {
+			value = check.Default
+		}
+		return expectValue(check.Key, value, check.Expect)
+
+	case config.PostureKindSysctl:
+		value, err := readSysctl(check.Key)
+		if err != nil {
+			return "error", err.Error()
+		}
+		return expectValue(check.Key, value, check.Expect)
+
+	case config.PostureKindFileRegex:
+		pattern, err := regexp.Compile("(?m)" + check.Pattern)
+		if err != nil {
+			return "error", fmt.Sprintf("invalid pattern: %v", err)
+		}
+		content, err := os.ReadFile(check.Path)
+		if err != nil {
+			if os.IsNotExist(err) {
+				return "fail", check.Path + " does not exist"
+			}
+			return "error", err.Error()
+		}
+		if pattern.Match(content) {
+			return "pass", ""
+		}
+		return "fail", fmt.Sprintf("%s does not match %s", check.Path, check.Pattern)
+
+	case config.PostureKindPathWritable:
+		offenders := worldWritablePathEntries()
+		if len(offenders) == 0 {
+			return "pass", ""
+		}
+		return "fail", worldWritableDetail(offenders)
+	}
+	return "error", fmt.Sprintf("unknown check kind %q", check.Kind)
+}
+
+func expectValue(key string, value string, expect []string) (string, string) {
+	for _, candidate := range expect {
+		if strings.EqualFold(strings.TrimSpace(value), candidate) {
+			return "pass", fmt.Sprintf("%s=%s", key, value)
+		}
+	}
+	return "fail", fmt.Sprintf("%s=%s, expected %s", key, value, strings.Join(expect, " or "))
+}
+
+// sshdConfigValue returns the first value of key in an sshd_config file,
+// following Include directives. As in sshd, the first occurrence wins and
+// Match blocks are ignored since they only apply conditionally.
+func sshdConfigValue(path string, key string) (string, bool, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", false, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(strings.Replace(line, "=", " ", 1))
+		if len(fields) < 2 {
+			continue
+		}
+
+		switch {
+		case strings.EqualFold(fields[0], "Match"):
+			return "", false, scanner.Err()
+		case strings.EqualFold(fields[0], "Include"):
+			for _, pattern := range fields[1:] {
+				if !filepath.IsAbs(pattern) {
+					pattern = filepath.Join("/etc/ssh", pattern)
+				}
+				matches, _ := filepath.Glob(pattern)
+				for _, included := range matches {
+					if value, found, err := sshdConfigValue(included, key); err == nil && found {
+						return value, true, nil
+					}
+				}
+			}
+		case strings.EqualFold(fields[0], key):
+			return fields[1], true, nil
+		}
+	}
+	return "", false, scanner.Err()
+}
+
+// worldWritableDetail lists the offending paths, keeping the detail short
+// when a whole directory of files is world-writable.
+func worldWritableDetail(offenders []string) string {
+	detail := "world-writable: " + strings.Join(offenders[:min(len(offenders), maxReportedWorldWritable)], ", ")
+	if len(offenders) > maxReportedWorldWritable {
+		detail += fmt.Sprintf(" and %d more", len(offenders)-maxReportedWorldWritable)
+	}
+	return detail
+}
+
+// worldWritablePathEntries returns PATH directories, and files directly in
+// them, that any user can modify. Sticky directories such as /tmp are
+// allowed since other users cannot replace their entries.
+func worldWritablePathEntries() []string {
+	dirs := append([]string{}, defaultPathDirs...)
+	for _, dir := range filepath.SplitList(os.Getenv("PATH")) {
+		if dir != "" && !slices.Contains(dirs, dir) {
+			dirs = append(dirs, dir)
+		}
+	}
+
+	var offenders []string
+	for _, dir := range dirs {
+		info, err := os.Stat(dir)
+		if err != nil || !info.IsDir() {
+			continue
+		}
+		if info.Mode().Perm()&0o002 != 0 && info.Mode()&os.ModeSticky == 0 {
+			offenders = append(offenders, dir)
+		}
+
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			continue
+		}
+		for _, entry := range entries {
+			if entry.Type()&os.ModeSymlink != 0 {
+				continue
+			}
+			entryInfo, err := entry.Info()
+			if err != nil {
+				continue
+			}
+			if entryInfo.Mode().Perm()&0o002 != 0 {
+				offenders = append(offenders, filepath.Join(dir, entry.Name()))
+			}
+		}
+	}
+	return offenders
+}
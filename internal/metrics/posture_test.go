@@ -0,0 +1,25 @@
+package metrics
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestWorldWritableDetail(t *testing.T) {
+	if got, want := worldWritableDetail([]string{"/usr/local/bin", "/usr/bin/tool"}), "world-writable: /usr/local/bin, /usr/bin/tool"; got != want {
+		t.Errorf("worldWritableDetail = %q, want %q", got, want)
+	}
+
+	var offenders []string
+	for i := 0; i < 250; i++ {
+		offenders = append(offenders, fmt.Sprintf("/opt/tools/bin/tool%03d", i))
+	}
+	got := worldWritableDetail(offenders)
+	if strings.Count(got, "/opt/tools/bin/") != maxReportedWorldWritable {
+		t.Errorf("worldWritableDetail lists %d paths, want %d", strings.Count(got, "/opt/tools/bin/"), maxReportedWorldWritable)
+	}
+	if !strings.HasPrefix(got, "world-writable: /opt/tools/bin/tool000, ") || !strings.HasSuffix(got, "/opt/tools/bin/tool019 and 230 more") {
+		t.Errorf("worldWritableDetail = %q", got)
+	}
+}
@@ -0,0 +1,228 @@
+package metrics
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+const maxReportedDeletedLibraries = 20
+
+// CollectRebootRequired reports whether the host needs a reboot to pick up
+// updates: the Debian/Ubuntu reboot-required flag, a newer installed kernel
+// than the running one, and shared libraries that were replaced on disk but
+// are still mapped by running processes.
+func CollectRebootRequired() (*RebootRequiredMetrics, error) {
+	result := &RebootRequiredMetrics{}
+
+	if _, err := os.Stat("/var/run/reboot-required"); err == nil {
+		result.Reasons = append(result.Reasons, "reboot_required_flag")
+		result.Packages = readRebootRequiredPackages("/var/run/reboot-required.pkgs")
+	}
+
+	var uts syscall.Utsname
+	if err := syscall.Uname(&uts); err == nil {
+		result.RunningKernel = utsnameString(uts.Release[:])
+	}
+	result.LatestKernel = latestInstalledKernel(result.RunningKernel)
+	if result.RunningKernel != "" && result.LatestKernel != "" &&
+		compareVersions(result.LatestKernel, result.RunningKernel) > 0 {
+		result.Reasons = append(result.Reasons, "kernel_update")
+	}
+
+	libraries, processes := findDeletedLibraries()
+	if len(libraries) > 0 {
+		result.Reasons = append(result.Reasons, "deleted_libraries")
+		result.AffectedProcesses = processes
+		if len(libraries) > maxReportedDeletedLibraries {
+			libraries = libraries[:maxReportedDeletedLibraries]
+		}
+		result.DeletedLibraries = libraries
+	}
+
+	result.Required = len(result.Reasons) > 0
+	return result, nil
+}
+
+func readRebootRequiredPackages(path string) []string {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil
+	}
+
+	seen := map[string]bool{}
+	var packages []string
+	for _, line := range strings.Split(string(content), "\n") {
+		name := strings.TrimSpace(line)
+		if name == "" || seen[name] {
+			continue
+		}
+		seen[name] = true
+		packages = append(packages, name)
+	}
+	return packages
+}
+
+// latestInstalledKernel returns the newest kernel release found in
+// /lib/modules or as /boot/vmlinuz-* images, see newestKernel.
+func latestInstalledKernel(running string) string {
+	var candidates []string
+	if entries, err := os.ReadDir("/lib/modules"); err == nil {
+		for _, entry := range entries {
+			if entry.IsDir() {
+				candidates = append(candidates, entry.Name())
+			}
+		}
+	}
+	if images, err := filepath.Glob("/boot/vmlinuz-*"); err == nil {
+		for _, image := range images {
+			candidates = append(candidates, strings.TrimPrefix(filepath.Base(image), "vmlinuz-"))
+		}
+	}
+
+	return newestKernel(candidates, running)
+}
+
+// newestKernel returns the newest of the kernel releases. When the running
+// release is known only those of the same flavour are considered, so an
+// installed but unused 6.1.0-20-amd64 does not make 6.1.0-18-cloud-amd64
+// look outdated.
+func newestKernel(releases []string, running string) string {
+	latest := ""
+	for _, release := range releases {
+		if running != "" && kernelFlavour(release) != kernelFlavour(running) {
+			continue
+		}
+		if latest == "" || compareVersions(release, latest) > 0 {
+			latest = release
+		}
+	}
+	return latest
+}
+
+// kernelFlavour returns the part of a kernel release naming the build
+// variant: the dash separated parts after the last one starting with a
+// digit, such as "cloud-amd64" in 6.1.0-18-cloud-amd64 or "generic" in
+// 5.15.0-91-generic, plus a "+debug" style suffix.
+func kernelFlavour(release string) string {
+	base, variant, hasVariant := strings.Cut(release, "+")
+	parts := strings.Split(base, "-")
+	i := len(parts)
+	for i > 0 && (parts[i-1] == "" || parts[i-1][0] < '0' || parts[i-1][0] > '9') {
+		i--
+	}
+	flavour := strings.Join(parts[i:], "-")
+	if hasVariant {
+		flavour += "+" + variant
+	}
+	return flavour
+}
+
+// findDeletedLibraries scans /proc/[pid]/maps for shared objects whose file
+// was deleted after being mapped, which is what package upgrades of libc or
+// openssl leave behind until the process restarts. Returns the sorted
+// library paths and the number of affected processes.
+func findDeletedLibraries() ([]string, int) {
+	maps, err := filepath.Glob("/proc/[0-9]*/maps")
+	if err != nil {
+		return nil, 0
+	}
+
+	libraries := map[string]bool{}
+	processes := 0
+	for _, path := range maps {
+		if scanMapsForDeletedLibraries(path, libraries) {
+			processes++
+		}
+	}
+
+	result := make([]string, 0, len(libraries))
+	for library := range libraries {
+		result = append(result, library)
+	}
+	sort.Strings(result)
+	return result, processes
+}
+
+func scanMapsForDeletedLibraries(path string, libraries map[string]bool) bool {
+	f, err := os.Open(path)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+
+	found := false
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if !strings.HasSuffix(line, " (deleted)") {
+			continue
+		}
+		fields := strings.Fields(strings.TrimSuffix(line, " (deleted)"))
+		if len(fields) < 6 {
+			continue
+		}
+		library := fields[5]
+		if !strings.HasPrefix(library, "/") || !strings.Contains(filepath.Base(library), ".so") {
+			continue
+		}
+		if strings.HasPrefix(library, "/dev/") || strings.HasPrefix(library, "/memfd:") {
+			continue
+		}
+		libraries[library] = true
+		found = true
+	}
+	return found
+}
+
+// compareVersions orders version strings such as kernel releases by
+// comparing runs of digits numerically and everything else lexically.
+func compareVersions(a string, b string) int {
+	for a != "" && b != "" {
+		aPart, aRest := splitVersionPart(a)
+		bPart, bRest := splitVersionPart(b)
+
+		aNum, aErr := strconv.ParseUint(aPart, 10, 64)
+		bNum, bErr := strconv.ParseUint(bPart, 10, 64)
+		switch {
+		case aErr == nil && bErr == nil:
+			if aNum != bNum {
+				if aNum < bNum {
+					return -1
+				}
+				return 1
+			}
+		case aErr == nil:
+			// Numbers sort after separators and suffixes: 6.1.10 > 6.1-rc1.
+			return 1
+		case bErr == nil:
+			return -1
+		default:
+			if c := strings.Compare(aPart, bPart); c != 0 {
+				return c
+			}
+		}
+		a, b = aRest, bRest
+	}
+	switch {
+	case a == "" && b == "":
+		return 0
+	case a == "":
+		return -1
+	default:
+		return 1
+	}
+}
+
+func splitVersionPart(value string) (string, string) {
+	isDigit := value[0] >= '0' && value[0] <= '9'
+	i := 1
+	for i < len(value) && (value[i] >= '0' && value[i] <= '9') == isDigit {
+		i++
+	}
+	return value[:i], value[i:]
+}
@@ -0,0 +1,78 @@
+package metrics
+
+import "testing"
+
+func TestCompareVersions(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"6.1.0-18-amd64", "6.1.0-18-amd64", 0},
+		{"6.1.0-18-amd64", "6.1.0-9-amd64", 1},
+		{"5.15.0-91-generic", "5.15.0-100-generic", -1},
+		{"6.10.0", "6.9.12", 1},
+		{"6.1", "6.1.1", -1},
+		{"6.1.10", "6.1-rc1", 1},
+		{"5.14.0-362.8.1.el9_3.x86_64", "5.14.0-362.24.1.el9_3.x86_64", -1},
+		{"5.14.0-427.el9.x86_64", "5.14.0-362.24.1.el9_3.x86_64", 1},
+		{"6.6.10-arch1-1", "6.6.9-arch1-1", 1},
+		{"6.6.10-arch1-2", "6.6.10-arch1-1", 1},
+		{"1.2.3-alpha", "1.2.3-beta", -1},
+	}
+	for _, tt := range tests {
+		if got := compareVersions(tt.a, tt.b); got != tt.want {
+			t.Errorf("compareVersions(%q, %q) = %d, want %d", tt.a, tt.b, got, tt.want)
+		}
+		if got := compareVersions(tt.b, tt.a); got != -tt.want {
+			t.Errorf("compareVersions(%q, %q) = %d, want %d", tt.b, tt.a, got, -tt.want)
+		}
+	}
+}
+
+func TestKernelFlavour(t *testing.T) {
+	tests := []struct {
+		release string
+		want    string
+	}{
+		{"6.1.0-18-cloud-amd64", "cloud-amd64"},
+		{"6.1.0-18-amd64", "amd64"},
+		{"6.1.0-18-rt-amd64", "rt-amd64"},
+		{"5.15.0-91-generic", "generic"},
+		{"6.8.0-1008-aws", "aws"},
+		{"5.14.0-362.8.1.el9_3.x86_64", ""},
+		{"5.14.0-362.8.1.el9_3.x86_64+debug", "+debug"},
+		{"6.6.10-arch1-1", ""},
+		{"6.1.70-1-lts", "lts"},
+		{"6.12.0", ""},
+	}
+	for _, tt := range tests {
+		if got := kernelFlavour(tt.release); got != tt.want {
+			t.Errorf("kernelFlavour(%q) = %q, want %q", tt.release, got, tt.want)
+		}
+	}
+}
+
+func TestNewestKernel(t *testing.T) {
+	installed := []string{
+		"6.1.0-18-cloud-amd64",
+		"6.1.0-20-amd64",
+		"6.1.0-17-cloud-amd64",
+		"6.1.0-20-rt-amd64",
+	}
+	tests := []struct {
+		running string
+		want    string
+	}{
+		{"6.1.0-18-cloud-amd64", "6.1.0-18-cloud-amd64"},
+		{"6.1.0-17-cloud-amd64", "6.1.0-18-cloud-amd64"},
+		{"6.1.0-18-amd64", "6.1.0-20-amd64"},
+		{"6.1.0-20-rt-amd64", "6.1.0-20-rt-amd64"},
+		{"6.1.0-20-arm64", ""},
+		{"", "6.1.0-20-rt-amd64"},
+	}
+	for _, tt := range tests {
+		if got := newestKernel(installed, tt.running); got != tt.want {
+			t.Errorf("newestKernel(running %q) = %q, want %q", tt.running, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,224 @@
+package metrics
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+)
+
+const (
+	utmpPath       = "/var/run/utmp"
+	btmpPath       = "/var/log/btmp"
+	utmpRecordSize = 384
+	utmpLoginProc  = 6 // LOGIN_PROCESS
+	utmpUserProc   = 7 // USER_PROCESS
+
+	maxAuthLogBytesPerRun = 16 << 20
+	maxAuthSources        = 5
+)
+
+var authLogPaths = []string{"/var/log/auth.log", "/var/log/secure"}
+
+// sshFailurePattern matches sshd's per-attempt failure lines, optionally
+// wrapped by rsyslog's "message repeated N times" compression.
+var sshFailurePattern = regexp.MustCompile(`sshd\[\d+\]: (?:message repeated (\d+) times: \[ )?Failed \S+ for (?:invalid user )?\S+ from (\S+)`)
+
+// utmpRecord is the fixed-size glibc struct utmp layout shared by utmp, wtmp
+// and btmp on 32- and 64-bit Linux.
+type utmpRecord struct {
+	Type    int16
+	_       int16
+	PID     int32
+	Line    [32]byte
+	ID      [4]byte
+	User    [32]byte
+	Host    [256]byte
+	Exit    [2]int16
+	Session int32
+	Sec     int32
+	Usec    int32
+	Addr    [4]int32
+	_       [20]byte
+}
+
+// CollectSessions lists interactive login sessions from utmp. Entries whose
+// process is gone are skipped, as utmp is not cleaned up after a crash.
+func CollectSessions() ([]SessionPayload, error) {
+	records, err := readUtmpRecords(utmpPath, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	var sessions []SessionPayload
+	for _, record := range records {
+		if record.Type != utmpUserProc {
+			continue
+		}
+		if _, err := os.Stat(filepath.Join("/proc", strconv.Itoa(int(record.PID)))); err != nil {
+			continue
+		}
+		sessions = append(sessions, SessionPayload{
+			User:      cString(record.User[:]),
+			TTY:       cString(record.Line[:]),
+			Host:      cString(record.Host[:]),
+			LoginTime: int64(record.Sec),
+		})
+	}
+	return sessions, nil
+}
+
+type authState struct {
+	AuthLogPath string      `json:"authLogPath,omitempty"`
+	AuthLog     *fileOffset `json:"authLog,omitempty"`
+	Btmp        *fileOffset `json:"btmp,omitempty"`
+}
+
+// CollectAuthFailures counts failed SSH logins in auth.log/secure and failed
+// login records in btmp since the last committed read positions. The new
+// positions are staged in pending; the first call only records them and
+// returns nil.
+func CollectAuthFailures(stateDir string, pending *PendingState) (*AuthFailuresPayload, error) {
+	stateFile := filepath.Join(stateDir, "auth_state.json")
+	var prev authState
+	hasPrev := loadState(stateFile, &prev) == nil
+
+	result := &AuthFailuresPayload{}
+	sources := map[string]int{}
+	current := authState{}
+
+	for _, path := range authLogPaths {
+		if _, err := os.Stat(path); err != nil {
+			continue
+		}
+		var prevOffset *fileOffset
+		if hasPrev && prev.AuthLogPath == path {
+			prevOffset = prev.AuthLog
+		}
+		offset, _, err := readNewLines(path, prevOffset, maxAuthLogBytesPerRun, func(line string) {
+			match := sshFailurePattern.FindStringSubmatch(line)
+			if match == nil {
+				return
+			}
+			count := 1
+			if match[1] != "" {
+				count, _ = strconv.Atoi(match[1])
+			}
+			result.FailedSSHLogins += count
+			sources[match[2]] += count
+		})
+		if err != nil {
+			return nil, fmt.Errorf("read %s: %w", path, err)
+		}
+		current.AuthLogPath = path
+		current.AuthLog = &offset
+		break
+	}
+
+	if info, err := os.Stat(btmpPath); err == nil {
+		var prevOffset *fileOffset
+		if hasPrev {
+			prevOffset = prev.Btmp
+		}
+		offset, failures, err := readNewBtmpRecords(info, prevOffset)
+		if err != nil {
+			return nil, fmt.Errorf("read %s: %w", btmpPath, err)
+		}
+		for _, record := range failures {
+			result.BtmpFailures++
+			if host := cString(record.Host[:]); host != "" {
+				sources[host]++
+			}
+		}
+		current.Btmp = &offset
+	}
+
+	pending.stage(stateFile, current)
+	if !hasPrev || (current.AuthLog == nil && current.Btmp == nil) {
+		return nil, nil
+	}
+
+	result.TopSources = topAuthSources(sources, maxAuthSources)
+	return result, nil
+}
+
+func readNewBtmpRecords(info os.FileInfo, prev *fileOffset) (fileOffset, []utmpRecord, error) {
+	current := fileOffset{Inode: fileInode(info), Offset: info.Size() - info.Size()%utmpRecordSize}
+	if prev == nil {
+		return current, nil, nil
+	}
+
+	start := prev.Offset
+	if prev.Inode != current.Inode || start > info.Size() {
+		start = 0
+	}
+	records, err := readUtmpRecords(btmpPath, start)
+	if err != nil {
+		return fileOffset{}, nil, err
+	}
+	current.Offset = start + int64(len(records))*utmpRecordSize
+
+	failures := records[:0]
+	for _, record := range records {
+		if record.Type == utmpLoginProc || record.Type == utmpUserProc {
+			failures = append(failures, record)
+		}
+	}
+	return current, failures, nil
+}
+
+func readUtmpRecords(path string, offset int64) ([]utmpRecord, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		return nil, err
+	}
+
+	reader := bufio.NewReader(f)
+	var records []utmpRecord
+	for {
+		var record utmpRecord
+		if err := binary.Read(reader, binary.LittleEndian, &record); err != nil {
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				break
+			}
+			return nil, err
+		}
+		records = append(records, record)
+	}
+	return records, nil
+}
+
+func topAuthSources(counts map[string]int, limit int) []AuthSourcePayload {
+	sources := make([]AuthSourcePayload, 0, len(counts))
+	for address, count := range counts {
+		sources = append(sources, AuthSourcePayload{Address: address, Count: count})
+	}
+	sort.Slice(sources, func(i, j int) bool {
+		if sources[i].Count != sources[j].Count {
+			return sources[i].Count > sources[j].Count
+		}
+		return sources[i].Address < sources[j].Address
+	})
+	if len(sources) > limit {
+		sources = sources[:limit]
+	}
+	return sources
+}
+
+func cString(field []byte) string {
+	if i := bytes.IndexByte(field, 0); i >= 0 {
+		field = field[:i]
+	}
+	return string(field)
+}
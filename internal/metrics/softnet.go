@@ -0,0 +1,195 @@
+package metrics
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+type SoftnetMetrics struct {
+	ProcessedPerSec   float64
+	DroppedPerSec     float64
+	TimeSqueezePerSec float64
+	CPUs              []SoftnetCPUMetrics
+}
+
+type SoftnetCPUMetrics struct {
+	CPU               int
+	ProcessedPerSec   float64
+	DroppedPerSec     float64
+	TimeSqueezePerSec float64
+}
+
+type InterfaceDropMetrics struct {
+	Name               string
+	RxMissedPerSec     float64
+	RxFifoErrorsPerSec float64
+	TxFifoErrorsPerSec float64
+}
+
+type softnetCounters struct {
+	CPU         int    `json:"cpu"`
+	Processed   uint64 `json:"processed"`
+	Dropped     uint64 `json:"dropped"`
+	TimeSqueeze uint64 `json:"timeSqueeze"`
+}
+
+type ifaceDropCounters struct {
+	RxMissed     uint64 `json:"rxMissed"`
+	RxFifoErrors uint64 `json:"rxFifoErrors"`
+	TxFifoErrors uint64 `json:"txFifoErrors"`
+}
+
+// readSoftnetStat parses /proc/net/softnet_stat. Each line is one CPU with
+// hexadecimal columns; the first three are processed, dropped and
+// time_squeeze. Newer kernels report the CPU id in column 13, older ones
+// only list online CPUs in order.
+func readSoftnetStat() ([]softnetCounters, error) {
+	f, err := os.Open("/proc/net/softnet_stat")
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var counters []softnetCounters
+	index := 0
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 3 {
+			continue
+		}
+
+		cpu := index
+		index++
+		if len(fields) >= 13 {
+			if id, err := strconv.ParseUint(fields[12], 16, 32); err == nil {
+				cpu = int(id)
+			}
+		}
+
+		processed, err := strconv.ParseUint(fields[0], 16, 64)
+		if err != nil {
+			continue
+		}
+		dropped, err := strconv.ParseUint(fields[1], 16, 64)
+		if err != nil {
+			continue
+		}
+		squeeze, err := strconv.ParseUint(fields[2], 16, 64)
+		if err != nil {
+			continue
+		}
+
+		counters = append(counters, softnetCounters{
+			CPU:         cpu,
+			Processed:   processed,
+			Dropped:     dropped,
+			TimeSqueeze: squeeze,
+		})
+	}
+	return counters, scanner.Err()
+}
+
+// readInterfaceDrops reads NIC-level drop counters from
+// /sys/class/net/*/statistics for all non-loopback interfaces.
+func readInterfaceDrops() (map[string]ifaceDropCounters, error) {
+	dirs, err := filepath.Glob("/sys/class/net/*/statistics")
+	if err != nil {
+		return nil, err
+	}
+
+	result := make(map[string]ifaceDropCounters, len(dirs))
+	for _, dir := range dirs {
+		iface := filepath.Base(filepath.Dir(dir))
+		if iface == "lo" {
+			continue
+		}
+		result[iface] = ifaceDropCounters{
+			RxMissed:     readUintFile(filepath.Join(dir, "rx_missed_errors")),
+			RxFifoErrors: readUintFile(filepath.Join(dir, "rx_fifo_errors")),
+			TxFifoErrors: readUintFile(filepath.Join(dir, "tx_fifo_errors")),
+		}
+	}
+	return result, nil
+}
+
+func softnetRates(prev []softnetCounters, current []softnetCounters, elapsed float64) *SoftnetMetrics {
+	if len(prev) == 0 || len(current) == 0 {
+		return nil
+	}
+
+	prevByCPU := make(map[int]softnetCounters, len(prev))
+	for _, counters := range prev {
+		prevByCPU[counters.CPU] = counters
+	}
+
+	result := &SoftnetMetrics{}
+	for _, cur := range current {
+		old, ok := prevByCPU[cur.CPU]
+		if !ok {
+			continue
+		}
+		// Counter reset detection (CPU hotplug or wraparound)
+		if cur.Processed < old.Processed || cur.Dropped < old.Dropped || cur.TimeSqueeze < old.TimeSqueeze {
+			continue
+		}
+
+		cpu := SoftnetCPUMetrics{
+			CPU:               cur.CPU,
+			ProcessedPerSec:   float64(cur.Processed-old.Processed) / elapsed,
+			DroppedPerSec:     float64(cur.Dropped-old.Dropped) / elapsed,
+			TimeSqueezePerSec: float64(cur.TimeSqueeze-old.TimeSqueeze) / elapsed,
+		}
+		result.ProcessedPerSec += cpu.ProcessedPerSec
+		result.DroppedPerSec += cpu.DroppedPerSec
+		result.TimeSqueezePerSec += cpu.TimeSqueezePerSec
+		result.CPUs = append(result.CPUs, cpu)
+	}
+	if len(result.CPUs) == 0 {
+		return nil
+	}
+	return result
+}
+
+func interfaceDropRates(prev map[string]ifaceDropCounters, current map[string]ifaceDropCounters, elapsed float64) []InterfaceDropMetrics {
+	names := make([]string, 0, len(current))
+	for name := range current {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var result []InterfaceDropMetrics
+	for _, name := range names {
+		cur := current[name]
+		old, ok := prev[name]
+		if !ok {
+			continue
+		}
+		if cur.RxMissed < old.RxMissed || cur.RxFifoErrors < old.RxFifoErrors || cur.TxFifoErrors < old.TxFifoErrors {
+			continue
+		}
+		result = append(result, InterfaceDropMetrics{
+			Name:               name,
+			RxMissedPerSec:     float64(cur.RxMissed-old.RxMissed) / elapsed,
+			RxFifoErrorsPerSec: float64(cur.RxFifoErrors-old.RxFifoErrors) / elapsed,
+			TxFifoErrorsPerSec: float64(cur.TxFifoErrors-old.TxFifoErrors) / elapsed,
+		})
+	}
+	return result
+}
+
+func readUintFile(path string) uint64 {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return 0
+	}
+	value, err := strconv.ParseUint(strings.TrimSpace(string(content)), 10, 64)
+	if err != nil {
+		return 0
+	}
+	return value
+}
@@ -0,0 +1,65 @@
+package metrics
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// loadState decodes a JSON state file written by saveState into out.
+func loadState(path string, out any) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, out)
+}
+
+// saveState atomically replaces a JSON state file, creating the state
+// directory on first use.
+func saveState(path string, state any) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return err
+	}
+	data, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// PendingState holds state files whose changes have been reported in a
+// payload that is not yet accepted by the server. Collectors stage their new
+// state here instead of saving it, and the caller commits it after a
+// successful send, so changes are detected and reported again until the
+// server has them.
+type PendingState struct {
+	files []pendingStateFile
+}
+
+type pendingStateFile struct {
+	path  string
+	state any
+}
+
+func (p *PendingState) stage(path string, state any) {
+	p.files = append(p.files, pendingStateFile{path: path, state: state})
+}
+
+// Commit saves the staged state files.
+func (p *PendingState) Commit() error {
+	var errs []error
+	for _, file := range p.files {
+		if err := saveState(file.path, file.state); err != nil {
+			errs = append(errs, fmt.Errorf("save %s: %w", filepath.Base(file.path), err))
+		}
+	}
+	p.files = nil
+	return errors.Join(errs...)
+}
@@ -0,0 +1,211 @@
+package metrics
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+const procSysRoot = "/proc/sys"
+
+// volatileSysctls change on their own and would show up as drift on every
+// run. A trailing dot matches every key below it and a "*" component matches
+// any single component, such as an interface name.
+var volatileSysctls = []string{
+	"kernel.ns_last_pid",
+	"kernel.hostname",
+	"kernel.domainname",
+	"kernel.perf_event_max_sample_rate",
+	"kernel.random.",
+	"fs.binfmt_misc.",
+	"fs.dentry-state",
+	"net.netfilter.nf_conntrack_count",
+	"net.ipv6.conf.*.stable_secret",
+}
+
+// CaptureSysctlBaseline reads all writable sysctls, optionally limited to
+// names starting with one of prefixes. Write-only and unreadable entries are
+// skipped.
+func CaptureSysctlBaseline(prefixes []string) (map[string]string, error) {
+	values := map[string]string{}
+	err := filepath.WalkDir(procSysRoot, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			if entry != nil && entry.IsDir() {
+				return fs.SkipDir
+			}
+			return nil
+		}
+		if entry.IsDir() {
+			return nil
+		}
+
+		key := sysctlKey(path)
+		if !hasAnyPrefix(key, prefixes) || isVolatileSysctl(key) {
+			return nil
+		}
+		info, err := entry.Info()
+		if err != nil || info.Mode().Perm()&0o200 == 0 {
+			return nil
+		}
+		value, err := readSysctl(key)
+		if err != nil {
+			return nil
+		}
+		values[key] = value
+		return nil
+	})
+	return values, err
+}
+
+func SaveSysctlBaseline(stateDir string, values map[string]string) error {
+	return saveState(filepath.Join(stateDir, "sysctl_baseline.json"), values)
+}
+
+type sysctlDriftState struct {
+	Drift map[string]string `json:"drift"`
+}
+
+// CollectSysctlDrift compares /proc/sys against the captured baseline and the
+// expected values from the config, which take precedence. It returns the
+// current drift and events for drift that appeared, changed value or was
+// resolved since the last committed call. Sysctls that no longer exist, such as
+// those of removed network interfaces, are not treated as drift.
+func CollectSysctlDrift(stateDir string, expected map[string]string, pending *PendingState) (*SysctlPayload, []EventPayload, error) {
+	wanted := map[string]string{}
+	if err := loadState(filepath.Join(stateDir, "sysctl_baseline.json"), &wanted); err != nil && !os.IsNotExist(err) {
+		return nil, nil, fmt.Errorf("load sysctl baseline: %w", err)
+	}
+	for key, value := range expected {
+		wanted[key] = normalizeSysctlValue(value)
+	}
+	if len(wanted) == 0 {
+		return nil, nil, nil
+	}
+
+	result := &SysctlPayload{}
+	drift := map[string]string{}
+	for _, key := range sortedKeys(wanted) {
+		actual, err := readSysctl(key)
+		if err != nil {
+			continue
+		}
+		result.Checked++
+		if actual != wanted[key] {
+			drift[key] = actual
+			result.Drift = append(result.Drift, SysctlDriftPayload{Key: key, Expected: wanted[key], Actual: actual})
+		}
+	}
+
+	stateFile := filepath.Join(stateDir, "sysctl_drift.json")
+	var prev sysctlDriftState
+	if err := loadState(stateFile, &prev); err != nil {
+		prev.Drift = map[string]string{}
+	}
+	pending.stage(stateFile, sysctlDriftState{Drift: drift})
+
+	now := time.Now().Unix()
+	var events []EventPayload
+	for _, key := range sortedKeys(drift) {
+		if old, ok := prev.Drift[key]; ok && old == drift[key] {
+			continue
+		}
+		events = append(events, EventPayload{
+			Type:    "sysctl_drift",
+			TS:      now,
+			Message: fmt.Sprintf("sysctl %s is %q, expected %q", key, drift[key], wanted[key]),
+			Details: map[string]any{"key": key, "expected": wanted[key], "actual": drift[key]},
+		})
+	}
+	for _, key := range sortedKeys(prev.Drift) {
+		if _, ok := drift[key]; ok {
+			continue
+		}
+		events = append(events, EventPayload{
+			Type:    "sysctl_drift_resolved",
+			TS:      now,
+			Message: fmt.Sprintf("sysctl %s is back to its expected value", key),
+			Details: map[string]any{"key": key, "expected": wanted[key]},
+		})
+	}
+	return result, events, nil
+}
+
+func readSysctl(key string) (string, error) {
+	content, err := os.ReadFile(sysctlPath(key))
+	if err != nil {
+		return "", err
+	}
+	return normalizeSysctlValue(string(content)), nil
+}
+
+// normalizeSysctlValue collapses the tab-separated multi-value format of
+// /proc/sys to single spaces, as sysctl(8) prints it.
+func normalizeSysctlValue(value string) string {
+	return strings.Join(strings.Fields(value), " ")
+}
+
+// sysctlPath maps a dotted sysctl name to its /proc/sys file. As in
+// sysctl(8), a dot inside a path component such as a VLAN interface name is
+// written as a slash: net.ipv4.conf.eth0/100.forwarding.
+func sysctlPath(key string) string {
+	parts := strings.Split(key, ".")
+	for i, part := range parts {
+		parts[i] = strings.ReplaceAll(part, "/", ".")
+	}
+	return filepath.Join(append([]string{procSysRoot}, parts...)...)
+}
+
+func sysctlKey(path string) string {
+	rel, err := filepath.Rel(procSysRoot, path)
+	if err != nil {
+		return path
+	}
+	parts := strings.Split(rel, string(filepath.Separator))
+	for i, part := range parts {
+		parts[i] = strings.ReplaceAll(part, ".", "/")
+	}
+	return strings.Join(parts, ".")
+}
+
+func isVolatileSysctl(key string) bool {
+	return slices.ContainsFunc(volatileSysctls, func(pattern string) bool {
+		return matchSysctlPattern(pattern, key)
+	})
+}
+
+func matchSysctlPattern(pattern string, key string) bool {
+	patternParts := strings.Split(pattern, ".")
+	keyParts := strings.Split(key, ".")
+	if patternParts[len(patternParts)-1] == "" {
+		patternParts = patternParts[:len(patternParts)-1]
+		if len(keyParts) <= len(patternParts) {
+			return false
+		}
+		keyParts = keyParts[:len(patternParts)]
+	}
+	if len(keyParts) != len(patternParts) {
+		return false
+	}
+	for i, part := range patternParts {
+		if part != "*" && part != keyParts[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func hasAnyPrefix(value string, prefixes []string) bool {
+	if len(prefixes) == 0 {
+		return true
+	}
+	for _, prefix := range prefixes {
+		if strings.HasPrefix(value, prefix) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,31 @@
+package metrics
+
+import "testing"
+
+func TestIsVolatileSysctl(t *testing.T) {
+	tests := []struct {
+		key  string
+		want bool
+	}{
+		{"kernel.ns_last_pid", true},
+		{"kernel.perf_event_max_sample_rate", true},
+		{"kernel.random.write_wakeup_threshold", true},
+		{"kernel.random.urandom_min_reseed_secs", true},
+		{"kernel.random", false},
+		{"kernel.randomize_va_space", false},
+		{"fs.dentry-state", true},
+		{"fs.binfmt_misc.status", true},
+		{"net.ipv6.conf.eth0.stable_secret", true},
+		{"net.ipv6.conf.eth0/100.stable_secret", true},
+		{"net.ipv6.conf.default.stable_secret", true},
+		{"net.ipv6.conf.eth0.forwarding", false},
+		{"net.ipv6.conf.stable_secret", false},
+		{"net.ipv4.ip_forward", false},
+		{"kernel.perf_event_paranoid", false},
+	}
+	for _, tt := range tests {
+		if got := isVolatileSysctl(tt.key); got != tt.want {
+			t.Errorf("isVolatileSysctl(%q) = %v, want %v", tt.key, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,266 @@
+package metrics
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	systemdBusName   = "org.freedesktop.systemd1"
+	systemdPath      = "/org/freedesktop/systemd1"
+	systemdManager   = "org.freedesktop.systemd1.Manager"
+	systemdUnit      = "org.freedesktop.systemd1.Unit"
+	systemdService   = "org.freedesktop.systemd1.Service"
+	dbusProperties   = "org.freedesktop.DBus.Properties"
+	maxSystemdUnits  = 100
+	cldExited        = 1
+	cldKilled        = 2
+	cldDumped        = 3
+	systemdNotLoaded = "not-found"
+)
+
+// SystemdTimeout bounds a whole systemd collection run.
+const SystemdTimeout = 10 * time.Second
+
+// SystemdBus is the subset of the systemd D-Bus API used by CollectSystemd.
+type SystemdBus interface {
+	// ListUnits returns every unit currently loaded by systemd.
+	ListUnits(ctx context.Context) ([]SystemdUnitStatus, error)
+	// UnitProperties loads the named unit and returns its state. The
+	// service fields are only filled in for .service units.
+	UnitProperties(ctx context.Context, name string) (*SystemdUnitProperties, error)
+	Close() error
+}
+
+type SystemdUnitStatus struct {
+	Name        string
+	Description string
+	LoadState   string
+	ActiveState string
+	SubState    string
+}
+
+type SystemdUnitProperties struct {
+	LoadState      string
+	ActiveState    string
+	SubState       string
+	NRestarts      *uint32
+	ExecMainCode   int32
+	ExecMainStatus int32
+	Result         string
+}
+
+// DialSystemdBus connects to systemd over the system bus. Returns
+// ErrNoSystemBus when the bus socket does not exist.
+func DialSystemdBus(ctx context.Context) (SystemdBus, error) {
+	conn, err := dialSystemBus(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &systemdDBus{conn: conn}, nil
+}
+
+type systemdDBus struct {
+	conn *dbusConn
+}
+
+func (b *systemdDBus) Close() error {
+	return b.conn.Close()
+}
+
+func (b *systemdDBus) ListUnits(ctx context.Context) ([]SystemdUnitStatus, error) {
+	b.applyDeadline(ctx)
+	reply, err := b.conn.call(systemdBusName, systemdPath, systemdManager, "ListUnits")
+	if err != nil {
+		return nil, err
+	}
+	if len(reply) != 1 {
+		return nil, fmt.Errorf("unexpected ListUnits reply")
+	}
+	rows, ok := reply[0].([]any)
+	if !ok {
+		return nil, fmt.Errorf("unexpected ListUnits reply")
+	}
+
+	units := make([]SystemdUnitStatus, 0, len(rows))
+	for _, row := range rows {
+		fields, ok := row.([]any)
+		if !ok || len(fields) < 5 {
+			continue
+		}
+		var values [5]string
+		for i := range values {
+			values[i], _ = fields[i].(string)
+		}
+		units = append(units, SystemdUnitStatus{
+			Name:        values[0],
+			Description: values[1],
+			LoadState:   values[2],
+			ActiveState: values[3],
+			SubState:    values[4],
+		})
+	}
+	return units, nil
+}
+
+func (b *systemdDBus) UnitProperties(ctx context.Context, name string) (*SystemdUnitProperties, error) {
+	b.applyDeadline(ctx)
+	// LoadUnit, unlike GetUnit, also resolves units that are not loaded,
+	// which then report LoadState "not-found".
+	reply, err := b.conn.call(systemdBusName, systemdPath, systemdManager, "LoadUnit", name)
+	if err != nil {
+		return nil, err
+	}
+	if len(reply) != 1 {
+		return nil, fmt.Errorf("unexpected LoadUnit reply")
+	}
+	path, ok := reply[0].(string)
+	if !ok {
+		return nil, fmt.Errorf("unexpected LoadUnit reply")
+	}
+
+	props := &SystemdUnitProperties{}
+	for property, out := range map[string]*string{
+		"LoadState":   &props.LoadState,
+		"ActiveState": &props.ActiveState,
+		"SubState":    &props.SubState,
+	} {
+		value, err := b.property(path, systemdUnit, property)
+		if err != nil {
+			return nil, err
+		}
+		*out, _ = value.(string)
+	}
+	if !strings.HasSuffix(name, ".service") || props.LoadState == systemdNotLoaded {
+		return props, nil
+	}
+
+	// NRestarts only exists since systemd 235; missing properties are left
+	// unset rather than failing the unit.
+	if value, err := b.property(path, systemdService, "NRestarts"); err == nil {
+		if restarts, ok := value.(uint32); ok {
+			props.NRestarts = &restarts
+		}
+	}
+	if value, err := b.property(path, systemdService, "ExecMainCode"); err == nil {
+		props.ExecMainCode, _ = value.(int32)
+	}
+	if value, err := b.property(path, systemdService, "ExecMainStatus"); err == nil {
+		props.ExecMainStatus, _ = value.(int32)
+	}
+	if value, err := b.property(path, systemdService, "Result"); err == nil {
+		props.Result, _ = value.(string)
+	}
+	return props, nil
+}
+
+func (b *systemdDBus) property(path string, iface string, name string) (any, error) {
+	reply, err := b.conn.call(systemdBusName, path, dbusProperties, "Get", iface, name)
+	if err != nil {
+		return nil, err
+	}
+	if len(reply) != 1 {
+		return nil, fmt.Errorf("unexpected reply for %s.%s", iface, name)
+	}
+	return reply[0], nil
+}
+
+func (b *systemdDBus) applyDeadline(ctx context.Context) {
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = b.conn.conn.SetDeadline(deadline)
+	}
+}
+
+// CollectSystemd reports failed units, units in an auto-restart loop and the
+// state of the configured critical units. A restart loop is a unit waiting in
+// auto-restart, or a service activating again after earlier restarts, since
+// one whose start takes long to fail is rarely caught in auto-restart. Units
+// that are starting for the first time are not reported as restarting. Unit
+// names without a suffix are treated as services.
+func CollectSystemd(ctx context.Context, bus SystemdBus, critical []string) (*SystemdPayload, error) {
+	units, err := bus.ListUnits(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("list units: %w", err)
+	}
+	sort.Slice(units, func(i, j int) bool { return units[i].Name < units[j].Name })
+
+	result := &SystemdPayload{}
+	for _, unit := range units {
+		switch {
+		case unit.ActiveState == "failed":
+			result.Failed = append(result.Failed, systemdUnitPayload(unit))
+		case unit.SubState == "auto-restart" || unit.ActiveState == "activating":
+			payload := systemdUnitPayload(unit)
+			if strings.HasSuffix(unit.Name, ".service") {
+				if props, err := bus.UnitProperties(ctx, unit.Name); err == nil {
+					payload.NRestarts = props.NRestarts
+				}
+			}
+			restarted := payload.NRestarts != nil && *payload.NRestarts > 0
+			if unit.SubState == "auto-restart" || restarted {
+				result.Restarting = append(result.Restarting, payload)
+			}
+		}
+	}
+	result.FailedCount = len(result.Failed)
+	result.RestartingCount = len(result.Restarting)
+	if len(result.Failed) > maxSystemdUnits {
+		result.Failed = result.Failed[:maxSystemdUnits]
+	}
+	if len(result.Restarting) > maxSystemdUnits {
+		result.Restarting = result.Restarting[:maxSystemdUnits]
+	}
+
+	for _, name := range critical {
+		if !strings.Contains(name, ".") {
+			name += ".service"
+		}
+		unit := SystemdCriticalUnitPayload{Name: name}
+		props, err := bus.UnitProperties(ctx, name)
+		if err != nil {
+			unit.Error = err.Error()
+			result.Critical = append(result.Critical, unit)
+			continue
+		}
+		unit.LoadState = props.LoadState
+		unit.ActiveState = props.ActiveState
+		unit.SubState = props.SubState
+		unit.NRestarts = props.NRestarts
+		unit.Result = props.Result
+		if props.ExecMainCode != 0 {
+			unit.ExitCode = exitCodeName(props.ExecMainCode)
+			status := props.ExecMainStatus
+			unit.ExitStatus = &status
+		}
+		unit.OK = props.LoadState == "loaded" && props.ActiveState == "active"
+		result.Critical = append(result.Critical, unit)
+	}
+	return result, nil
+}
+
+func systemdUnitPayload(unit SystemdUnitStatus) SystemdUnitPayload {
+	return SystemdUnitPayload{
+		Name:        unit.Name,
+		Description: unit.Description,
+		LoadState:   unit.LoadState,
+		ActiveState: unit.ActiveState,
+		SubState:    unit.SubState,
+	}
+}
+
+// exitCodeName maps the siginfo si_code systemd reports as ExecMainCode.
+// For "exited" the status is the exit code, otherwise the signal number.
+func exitCodeName(code int32) string {
+	switch code {
+	case cldExited:
+		return "exited"
+	case cldKilled:
+		return "killed"
+	case cldDumped:
+		return "dumped"
+	}
+	return fmt.Sprintf("code %d", code)
+}
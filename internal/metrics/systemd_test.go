@@ -0,0 +1,141 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+type fakeSystemdBus struct {
+	units []SystemdUnitStatus
+	props map[string]*SystemdUnitProperties
+}
+
+func (b *fakeSystemdBus) ListUnits(ctx context.Context) ([]SystemdUnitStatus, error) {
+	return b.units, nil
+}
+
+func (b *fakeSystemdBus) UnitProperties(ctx context.Context, name string) (*SystemdUnitProperties, error) {
+	if name == "broken.service" {
+		return nil, errors.New("org.freedesktop.DBus.Error.AccessDenied: denied")
+	}
+	if props, ok := b.props[name]; ok {
+		return props, nil
+	}
+	return &SystemdUnitProperties{LoadState: systemdNotLoaded, ActiveState: "inactive", SubState: "dead"}, nil
+}
+
+func (b *fakeSystemdBus) Close() error {
+	return nil
+}
+
+func uint32Ptr(v uint32) *uint32 {
+	return &v
+}
+
+func TestCollectSystemd(t *testing.T) {
+	bus := &fakeSystemdBus{
+		units: []SystemdUnitStatus{
+			{Name: "nginx.service", LoadState: "loaded", ActiveState: "active", SubState: "running"},
+			{Name: "backup.service", LoadState: "loaded", ActiveState: "failed", SubState: "failed"},
+			{Name: "flappy.service", LoadState: "loaded", ActiveState: "activating", SubState: "auto-restart"},
+			{Name: "slow.service", LoadState: "loaded", ActiveState: "activating", SubState: "start"},
+			{Name: "hanging.service", LoadState: "loaded", ActiveState: "activating", SubState: "start"},
+			{Name: "legacy.service", LoadState: "masked", ActiveState: "inactive", SubState: "dead"},
+			{Name: "data.mount", LoadState: "loaded", ActiveState: "failed", SubState: "failed"},
+		},
+		props: map[string]*SystemdUnitProperties{
+			"nginx.service": {LoadState: "loaded", ActiveState: "active", SubState: "running", NRestarts: uint32Ptr(0)},
+			"flappy.service": {
+				LoadState:      "loaded",
+				ActiveState:    "activating",
+				SubState:       "auto-restart",
+				NRestarts:      uint32Ptr(42),
+				ExecMainCode:   cldExited,
+				ExecMainStatus: 1,
+				Result:         "exit-code",
+			},
+			"legacy.service": {LoadState: "masked", ActiveState: "inactive", SubState: "dead"},
+			"slow.service":   {LoadState: "loaded", ActiveState: "activating", SubState: "start", NRestarts: uint32Ptr(0)},
+			// Times out on every start, so it is almost always activating.
+			"hanging.service": {LoadState: "loaded", ActiveState: "activating", SubState: "start", NRestarts: uint32Ptr(7)},
+		},
+	}
+
+	result, err := CollectSystemd(context.Background(), bus, []string{"nginx", "flappy.service", "legacy", "gone", "broken"})
+	if err != nil {
+		t.Fatalf("CollectSystemd: %v", err)
+	}
+
+	if result.FailedCount != 2 || len(result.Failed) != 2 {
+		t.Fatalf("failed = %d %+v, want backup.service and data.mount", result.FailedCount, result.Failed)
+	}
+	if result.Failed[0].Name != "backup.service" || result.Failed[1].Name != "data.mount" {
+		t.Errorf("failed units = %+v, want sorted backup.service, data.mount", result.Failed)
+	}
+
+	// A unit starting for the first time is not restarting; one activating
+	// again after restarts is.
+	if result.RestartingCount != 2 || len(result.Restarting) != 2 {
+		t.Fatalf("restarting = %d %+v, want flappy.service and hanging.service", result.RestartingCount, result.Restarting)
+	}
+	restarting := result.Restarting[0]
+	if restarting.Name != "flappy.service" || restarting.NRestarts == nil || *restarting.NRestarts != 42 {
+		t.Errorf("restarting unit = %+v, want flappy.service with 42 restarts", restarting)
+	}
+	restarting = result.Restarting[1]
+	if restarting.Name != "hanging.service" || restarting.NRestarts == nil || *restarting.NRestarts != 7 || restarting.SubState != "start" {
+		t.Errorf("restarting unit = %+v, want hanging.service activating with 7 restarts", restarting)
+	}
+
+	if len(result.Critical) != 5 {
+		t.Fatalf("critical = %+v, want 5 units", result.Critical)
+	}
+	byName := map[string]SystemdCriticalUnitPayload{}
+	for _, unit := range result.Critical {
+		byName[unit.Name] = unit
+	}
+
+	if unit := byName["nginx.service"]; !unit.OK || unit.Error != "" {
+		t.Errorf("nginx.service = %+v, want OK", unit)
+	}
+
+	flappy := byName["flappy.service"]
+	if flappy.OK {
+		t.Errorf("flappy.service reported OK while in auto-restart")
+	}
+	if flappy.NRestarts == nil || *flappy.NRestarts != 42 || flappy.Result != "exit-code" {
+		t.Errorf("flappy.service = %+v, want 42 restarts and result exit-code", flappy)
+	}
+	if flappy.ExitCode != "exited" || flappy.ExitStatus == nil || *flappy.ExitStatus != 1 {
+		t.Errorf("flappy.service exit = %q %v, want exited 1", flappy.ExitCode, flappy.ExitStatus)
+	}
+
+	if unit := byName["legacy.service"]; unit.OK || unit.LoadState != "masked" {
+		t.Errorf("legacy.service = %+v, want masked and not OK", unit)
+	}
+	if unit := byName["gone.service"]; unit.OK || unit.LoadState != systemdNotLoaded {
+		t.Errorf("gone.service = %+v, want not-found and not OK", unit)
+	}
+	if unit := byName["broken.service"]; unit.OK || unit.Error == "" {
+		t.Errorf("broken.service = %+v, want an error", unit)
+	}
+}
+
+func TestCollectSystemdCapsUnitLists(t *testing.T) {
+	bus := &fakeSystemdBus{}
+	for i := 0; i < maxSystemdUnits+5; i++ {
+		bus.units = append(bus.units, SystemdUnitStatus{
+			Name:        "unit" + string(rune('a'+i/26)) + string(rune('a'+i%26)) + ".timer",
+			ActiveState: "failed",
+		})
+	}
+
+	result, err := CollectSystemd(context.Background(), bus, nil)
+	if err != nil {
+		t.Fatalf("CollectSystemd: %v", err)
+	}
+	if result.FailedCount != maxSystemdUnits+5 || len(result.Failed) != maxSystemdUnits {
+		t.Errorf("failed = %d listed %d, want %d listed %d", result.FailedCount, len(result.Failed), maxSystemdUnits+5, maxSystemdUnits)
+	}
+}
@@ -0,0 +1,149 @@
+package metrics
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"math"
+	"net"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/MightyToolkit/mightymonitor-agent/internal/config"
+)
+
+// CollectTLSCertificates inspects the configured endpoints and PEM files.
+// Endpoints are checked concurrently, each until its own timeout or until ctx
+// is done.
+func CollectTLSCertificates(ctx context.Context, cfg *config.TLSCertificatesConfig) []TLSCertificatePayload {
+	if cfg == nil {
+		return nil
+	}
+	now := time.Now()
+	results := runChecks(cfg.Endpoints, func(endpoint config.TLSEndpoint) TLSCertificatePayload {
+		return checkTLSEndpoint(ctx, endpoint, now)
+	})
+
+	for _, pattern := range cfg.Files {
+		paths, err := filepath.Glob(pattern)
+		if err == nil && len(paths) == 0 {
+			err = fmt.Errorf("no files match %s", pattern)
+		}
+		if err != nil {
+			results = append(results, TLSCertificatePayload{Source: "file", Target: pattern, Error: err.Error()})
+			continue
+		}
+		for _, path := range paths {
+			results = append(results, checkTLSFile(path, now))
+		}
+	}
+	return results
+}
+
+func checkTLSEndpoint(ctx context.Context, endpoint config.TLSEndpoint, now time.Time) TLSCertificatePayload {
+	result := TLSCertificatePayload{Source: "endpoint", Target: endpoint.Address}
+	host, _, err := net.SplitHostPort(endpoint.Address)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	serverName := endpoint.ServerName
+	if serverName == "" {
+		serverName = host
+	}
+	result.ServerName = serverName
+
+	ctx, cancel := context.WithTimeout(ctx, checkTimeout(endpoint.TimeoutSeconds))
+	defer cancel()
+
+	// Verification is done separately so expired or untrusted certificates
+	// are still reported.
+	dialer := &tls.Dialer{Config: &tls.Config{
+		ServerName:         serverName,
+		InsecureSkipVerify: true,
+	}}
+	conn, err := dialer.DialContext(ctx, "tcp", endpoint.Address)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	defer conn.Close()
+
+	certs := conn.(*tls.Conn).ConnectionState().PeerCertificates
+	if len(certs) == 0 {
+		result.Error = "server sent no certificates"
+		return result
+	}
+	describeCertificate(&result, certs, serverName, now)
+	return result
+}
+
+func checkTLSFile(path string, now time.Time) TLSCertificatePayload {
+	result := TLSCertificatePayload{Source: "file", Target: path}
+	content, err := os.ReadFile(path)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+
+	var certs []*x509.Certificate
+	for {
+		var block *pem.Block
+		block, content = pem.Decode(content)
+		if block == nil {
+			break
+		}
+		// Combined files may also hold the private key.
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			result.Error = fmt.Sprintf("parse certificate: %v", err)
+			return result
+		}
+		certs = append(certs, cert)
+	}
+	if len(certs) == 0 {
+		result.Error = "no PEM certificates found"
+		return result
+	}
+	describeCertificate(&result, certs, "", now)
+	return result
+}
+
+// describeCertificate fills in the leaf certificate details and verifies the
+// chain, treating every certificate after the leaf as an intermediate.
+func describeCertificate(result *TLSCertificatePayload, certs []*x509.Certificate, serverName string, now time.Time) {
+	leaf := certs[0]
+	result.Subject = leaf.Subject.String()
+	result.Issuer = leaf.Issuer.String()
+	result.SerialNumber = leaf.SerialNumber.Text(16)
+	result.NotBefore = leaf.NotBefore.Unix()
+	result.NotAfter = leaf.NotAfter.Unix()
+	days := math.Round(leaf.NotAfter.Sub(now).Hours()/24*10) / 10
+	result.DaysUntilExpiry = &days
+
+	result.SANs = append(result.SANs, leaf.DNSNames...)
+	for _, ip := range leaf.IPAddresses {
+		result.SANs = append(result.SANs, ip.String())
+	}
+
+	intermediates := x509.NewCertPool()
+	for _, cert := range certs[1:] {
+		intermediates.AddCert(cert)
+	}
+	_, err := leaf.Verify(x509.VerifyOptions{
+		DNSName:       serverName,
+		Intermediates: intermediates,
+		CurrentTime:   now,
+	})
+	if err != nil {
+		result.ChainError = err.Error()
+		return
+	}
+	result.ChainValid = true
+}
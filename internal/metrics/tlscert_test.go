@@ -0,0 +1,163 @@
+package metrics
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"log"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/MightyToolkit/mightymonitor-agent/internal/config"
+)
+
+func TestCheckTLSEndpoint(t *testing.T) {
+	var mu sync.Mutex
+	var serverNames []string
+	server := httptest.NewUnstartedServer(http.NotFoundHandler())
+	server.TLS = &tls.Config{GetConfigForClient: func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		serverNames = append(serverNames, hello.ServerName)
+		return nil, nil
+	}}
+	// The check hangs up right after the handshake.
+	server.Config.ErrorLog = log.New(io.Discard, "", 0)
+	server.StartTLS()
+	defer server.Close()
+
+	now := time.Now()
+	cert := server.Certificate()
+	address := server.Listener.Addr().String()
+
+	result := checkTLSEndpoint(context.Background(), config.TLSEndpoint{Address: address, ServerName: "example.com"}, now)
+	if result.Error != "" {
+		t.Fatalf("checkTLSEndpoint error: %s", result.Error)
+	}
+	if result.Source != "endpoint" || result.Target != address || result.ServerName != "example.com" {
+		t.Errorf("result = %+v", result)
+	}
+	// The httptest certificate is self-signed, so the chain does not verify
+	// but the certificate is still described.
+	if result.ChainValid || result.ChainError == "" {
+		t.Errorf("chain valid %v error %q, want a chain error", result.ChainValid, result.ChainError)
+	}
+	wantSANs := slices.Clone(cert.DNSNames)
+	for _, ip := range cert.IPAddresses {
+		wantSANs = append(wantSANs, ip.String())
+	}
+	if !slices.Equal(result.SANs, wantSANs) || !slices.Contains(result.SANs, "127.0.0.1") {
+		t.Errorf("SANs = %v, want %v", result.SANs, wantSANs)
+	}
+	wantDays := cert.NotAfter.Sub(now).Hours() / 24
+	if result.DaysUntilExpiry == nil || *result.DaysUntilExpiry < wantDays-0.1 || *result.DaysUntilExpiry > wantDays+0.1 {
+		t.Errorf("days until expiry = %v, want about %.1f", result.DaysUntilExpiry, wantDays)
+	}
+	if result.NotAfter != cert.NotAfter.Unix() || result.SerialNumber != cert.SerialNumber.Text(16) {
+		t.Errorf("not after %d serial %s, want %d %s", result.NotAfter, result.SerialNumber, cert.NotAfter.Unix(), cert.SerialNumber.Text(16))
+	}
+
+	// Without a server name the host of the address is used, which is an IP
+	// and therefore not sent as SNI.
+	result = checkTLSEndpoint(context.Background(), config.TLSEndpoint{Address: address}, now)
+	if result.Error != "" || result.ServerName != "127.0.0.1" {
+		t.Errorf("result = %+v, want server name 127.0.0.1", result)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if !slices.Equal(serverNames, []string{"example.com", ""}) {
+		t.Errorf("SNI = %q, want example.com and none for the IP address", serverNames)
+	}
+}
+
+func TestCheckTLSEndpointErrors(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	address := listener.Addr().String()
+	listener.Close()
+
+	for _, endpoint := range []config.TLSEndpoint{
+		{Address: "missing-port"},
+		{Address: address, TimeoutSeconds: 1},
+	} {
+		if result := checkTLSEndpoint(context.Background(), endpoint, time.Now()); result.Error == "" || result.DaysUntilExpiry != nil {
+			t.Errorf("checkTLSEndpoint(%s) = %+v, want an error", endpoint.Address, result)
+		}
+	}
+}
+
+func TestCheckTLSFile(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(0x2a),
+		Subject:      pkix.Name{CommonName: "mail.example.com"},
+		DNSNames:     []string{"mail.example.com", "smtp.example.com"},
+		NotBefore:    now.Add(-time.Hour),
+		NotAfter:     now.Add(10 * 24 * time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+
+	dir := t.TempDir()
+	combined := filepath.Join(dir, "combined.pem")
+	if err := os.WriteFile(combined, append(keyPEM, certPEM...), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	keyOnly := filepath.Join(dir, "key.pem")
+	if err := os.WriteFile(keyOnly, keyPEM, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	result := checkTLSFile(combined, now)
+	if result.Error != "" {
+		t.Fatalf("checkTLSFile error: %s", result.Error)
+	}
+	if result.Source != "file" || result.Subject != "CN=mail.example.com" || result.SerialNumber != "2a" {
+		t.Errorf("result = %+v", result)
+	}
+	if !slices.Equal(result.SANs, []string{"mail.example.com", "smtp.example.com"}) {
+		t.Errorf("SANs = %v", result.SANs)
+	}
+	if result.DaysUntilExpiry == nil || *result.DaysUntilExpiry != 10 {
+		t.Errorf("days until expiry = %v, want 10", result.DaysUntilExpiry)
+	}
+	if result.ChainValid || result.ChainError == "" {
+		t.Errorf("self-signed file chain valid %v error %q, want a chain error", result.ChainValid, result.ChainError)
+	}
+
+	if result := checkTLSFile(keyOnly, now); result.Error != "no PEM certificates found" || result.DaysUntilExpiry != nil {
+		t.Errorf("key only file = %+v, want no certificates error", result)
+	}
+	if result := checkTLSFile(filepath.Join(dir, "missing.pem"), now); result.Error == "" {
+		t.Errorf("missing file = %+v, want an error", result)
+	}
+}